@@ -0,0 +1,475 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"mime"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// requireAdmin 校验 X-Admin-Key 头，未配置 ADMIN_KEY 时管理接口整体关闭
+func (s *FileServer) requireAdmin(c *fiber.Ctx) error {
+	if s.config.AdminKey == "" {
+		return c.Status(404).SendString("Not found")
+	}
+	key := c.Get("X-Admin-Key")
+	if subtle.ConstantTimeCompare([]byte(key), []byte(s.config.AdminKey)) != 1 {
+		return c.Status(401).SendString("Invalid admin key")
+	}
+	setNoIndex(c)
+	return c.Next()
+}
+
+// handleListPaths 列出所有路径及其文件数和总大小
+func (s *FileServer) handleListPaths(c *fiber.Ctx) error {
+	orderBy := "total_bytes DESC"
+	switch c.Query("sort") {
+	case "", "size":
+	case "count":
+		orderBy = "file_count DESC"
+	case "path":
+		orderBy = "path ASC"
+	default:
+		return c.Status(400).SendString("Invalid sort, expected size, count or path")
+	}
+
+	rows, err := s.db.Query(`
+       SELECT path, COUNT(*) AS file_count, COALESCE(SUM(file_size), 0) AS total_bytes
+       FROM files
+       GROUP BY path
+       ORDER BY ` + orderBy)
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	defer rows.Close()
+
+	paths := []fiber.Map{}
+	for rows.Next() {
+		var path string
+		var fileCount, totalBytes int64
+		if err := rows.Scan(&path, &fileCount, &totalBytes); err != nil {
+			return dbUnavailable(c, err)
+		}
+		paths = append(paths, fiber.Map{
+			"path":       path,
+			"fileCount":  fileCount,
+			"totalBytes": totalBytes,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	return sendJSON(c, paths)
+}
+
+// wantDiskPath 管理接口的文件列表是否附带磁盘路径 (?diskPath=1)
+func wantDiskPath(c *fiber.Ctx) bool {
+	show, _ := strconv.ParseBool(c.Query("diskPath"))
+	return show
+}
+
+// diskPath 文件在磁盘上的绝对路径，按记录的 storage_dir 计算 (分片布局下包含分片目录)；
+// 存储在数据库中的文件没有磁盘路径，返回空字符串
+func (s *FileServer) diskPath(storageDir, filename string, inDB bool) string {
+	if inDB {
+		return ""
+	}
+	p := filepath.Join(s.uploadDir, storageDir, filename)
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+// sizeBucketBounds 文件大小分布各区间的上界 (不含)，最后一个区间没有上界
+var sizeBucketBounds = []int64{
+	1 << 10, 10 << 10, 100 << 10,
+	1 << 20, 10 << 20, 100 << 20,
+	1 << 30,
+}
+
+// handleSizeHistogram 按大小区间统计文件数与字节数，以及全部文件的总量与平均大小，
+// 在数据库中分组汇总，不读取逐条记录
+func (s *FileServer) handleSizeHistogram(c *fiber.Ctx) error {
+	var bucketExpr strings.Builder
+	args := make([]interface{}, len(sizeBucketBounds))
+	bucketExpr.WriteString("CASE")
+	for i, bound := range sizeBucketBounds {
+		fmt.Fprintf(&bucketExpr, " WHEN file_size < ? THEN %d", i)
+		args[i] = bound
+	}
+	fmt.Fprintf(&bucketExpr, " ELSE %d END", len(sizeBucketBounds))
+
+	rows, err := s.db.Query(`
+       SELECT `+bucketExpr.String()+` AS bucket, COUNT(*), COALESCE(SUM(file_size), 0)
+       FROM files
+       GROUP BY bucket
+   `, args...)
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	defer rows.Close()
+
+	counts := make([]int64, len(sizeBucketBounds)+1)
+	sizes := make([]int64, len(sizeBucketBounds)+1)
+	var totalFiles, totalBytes int64
+	for rows.Next() {
+		var bucket int
+		var files, bytes int64
+		if err := rows.Scan(&bucket, &files, &bytes); err != nil {
+			return dbUnavailable(c, err)
+		}
+		counts[bucket], sizes[bucket] = files, bytes
+		totalFiles += files
+		totalBytes += bytes
+	}
+	if err := rows.Err(); err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	buckets := make([]fiber.Map, len(counts))
+	var min int64
+	for i := range counts {
+		bucket := fiber.Map{"minBytes": min, "maxBytes": nil, "files": counts[i], "bytes": sizes[i]}
+		if i < len(sizeBucketBounds) {
+			bucket["maxBytes"] = sizeBucketBounds[i]
+			bucket["label"] = formatFileSize(min) + "-" + formatFileSize(sizeBucketBounds[i])
+			min = sizeBucketBounds[i]
+		} else {
+			bucket["label"] = formatFileSize(min) + "+"
+		}
+		buckets[i] = bucket
+	}
+
+	var averageBytes int64
+	if totalFiles > 0 {
+		averageBytes = totalBytes / totalFiles
+	}
+	return sendJSON(c, fiber.Map{
+		"totalFiles":   totalFiles,
+		"totalBytes":   totalBytes,
+		"averageBytes": averageBytes,
+		"buckets":      buckets,
+	})
+}
+
+// mimeCategories 存储占用统计中的类型分类，按此顺序返回
+var mimeCategories = []string{"image", "video", "archive", "text", "other"}
+
+// mimeCategory 把 MIME 类型归入 mimeCategories 中的一类，无法识别的归入 other
+func mimeCategory(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return "other"
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return "image"
+	case strings.HasPrefix(mediaType, "video/"):
+		return "video"
+	case isCompressibleType(mediaType):
+		return "text"
+	}
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/x-tar",
+		"application/x-7z-compressed", "application/x-rar-compressed", "application/vnd.rar",
+		"application/x-bzip2", "application/x-xz", "application/zstd", "application/java-archive":
+		return "archive"
+	}
+	return "other"
+}
+
+// handleMimeUsage 按类型分类 (图片、视频、压缩包、文本、其他) 统计文件数与占用的存储空间，
+// 在数据库中按 MIME 类型分组汇总后再归类；percent 为该类占实际存储字节数的百分比
+func (s *FileServer) handleMimeUsage(c *fiber.Ctx) error {
+	rows, err := s.db.Query(`
+       SELECT COALESCE(mime_type, ''), COUNT(*), COALESCE(SUM(file_size), 0),
+              COALESCE(SUM(COALESCE(stored_size, file_size)), 0)
+       FROM files
+       GROUP BY mime_type
+   `)
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	defer rows.Close()
+
+	type usage struct{ files, bytes, storedBytes int64 }
+	byCategory := make(map[string]*usage, len(mimeCategories))
+	for _, category := range mimeCategories {
+		byCategory[category] = &usage{}
+	}
+	var total usage
+	for rows.Next() {
+		var mimeType string
+		var u usage
+		if err := rows.Scan(&mimeType, &u.files, &u.bytes, &u.storedBytes); err != nil {
+			return dbUnavailable(c, err)
+		}
+		sum := byCategory[mimeCategory(mimeType)]
+		sum.files += u.files
+		sum.bytes += u.bytes
+		sum.storedBytes += u.storedBytes
+		total.files += u.files
+		total.bytes += u.bytes
+		total.storedBytes += u.storedBytes
+	}
+	if err := rows.Err(); err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	categories := make([]fiber.Map, len(mimeCategories))
+	for i, category := range mimeCategories {
+		u := byCategory[category]
+		var percent float64
+		if total.storedBytes > 0 {
+			percent = math.Round(float64(u.storedBytes)*1000/float64(total.storedBytes)) / 10
+		}
+		categories[i] = fiber.Map{
+			"category":    category,
+			"files":       u.files,
+			"bytes":       u.bytes,
+			"storedBytes": u.storedBytes,
+			"percent":     percent,
+		}
+	}
+	return sendJSON(c, fiber.Map{
+		"totalFiles":       total.files,
+		"totalBytes":       total.bytes,
+		"totalStoredBytes": total.storedBytes,
+		"categories":       categories,
+	})
+}
+
+// /api/recent 默认与最多返回的文件数
+const (
+	defaultRecentLimit = 20
+	maxRecentLimit     = 200
+)
+
+// handleRecentUploads 列出最近上传的文件及其链接、大小与过期时间，curl/wget 返回纯文本；
+// ?sort=expiry 时按过期时间从近到远排列，永久保留的文件排在最后，?diskPath=1 时附带磁盘路径
+func (s *FileServer) handleRecentUploads(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", defaultRecentLimit)
+	if limit <= 0 || limit > maxRecentLimit {
+		return c.Status(400).SendString(fmt.Sprintf("Invalid limit, expected 1 to %d", maxRecentLimit))
+	}
+	orderBy := "id DESC"
+	switch c.Query("sort") {
+	case "", "recent":
+	case "expiry":
+		orderBy = "expires_at IS NULL, expires_at ASC, id ASC"
+	default:
+		return c.Status(400).SendString("Invalid sort, expected recent or expiry")
+	}
+	showDiskPath := wantDiskPath(c)
+
+	rows, err := s.db.Query(`
+       SELECT path, filename, encoded_filename, file_size, upload_time, expires_at, download_count,
+              COALESCE(storage_dir, path), content IS NOT NULL
+       FROM files
+       ORDER BY `+orderBy+`
+       LIMIT ?
+   `, limit)
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	defer rows.Close()
+
+	files := []fiber.Map{}
+	var b strings.Builder
+	for rows.Next() {
+		var path, filename, encodedFilename, storageDir string
+		var fileSize, downloadCount int64
+		var uploadTime time.Time
+		var expiresAt sql.NullTime
+		var inDB bool
+		if err := rows.Scan(&path, &filename, &encodedFilename, &fileSize, &uploadTime, &expiresAt, &downloadCount,
+			&storageDir, &inDB); err != nil {
+			return dbUnavailable(c, err)
+		}
+		fileURL := fmt.Sprintf("%s/%s/%s", baseURL(c), path, encodedFilename)
+		file := fiber.Map{
+			"path":          path,
+			"filename":      filename,
+			"url":           fileURL,
+			"size":          fileSize,
+			"uploadTime":    uploadTime.Local().Format(timeLayout),
+			"downloadCount": downloadCount,
+		}
+		setExpiry(file, expiresAt)
+		diskPath := s.diskPath(storageDir, filename, inDB)
+		if showDiskPath {
+			file["diskPath"] = nullIfEmpty(diskPath)
+		}
+		files = append(files, file)
+		expires := "never"
+		if expiresAt.Valid {
+			expires = expiresAt.Time.Local().Format(timeLayout)
+		}
+		fmt.Fprintf(&b, "%s  %10d  expires %s  %s",
+			uploadTime.Local().Format(timeLayout), fileSize, expires, fileURL)
+		if showDiskPath {
+			if diskPath == "" {
+				diskPath = "(database)"
+			}
+			fmt.Fprintf(&b, "  %s", diskPath)
+		}
+		b.WriteString("\n")
+	}
+	if err := rows.Err(); err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	if isTextPreferred(c) {
+		if len(files) == 0 {
+			return c.Type("text").SendString("No files\n")
+		}
+		return c.Type("text").SendString(b.String())
+	}
+	return sendJSON(c, files)
+}
+
+// uploaderIP 解析路径中的 IP 参数，格式不合法时返回空字符串
+func uploaderIP(c *fiber.Ctx) string {
+	ip := net.ParseIP(c.Params("ip"))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// handleUploaderStats 列出某个 IP 上传的全部文件及其数量与总大小，用于滥用排查；
+// ?diskPath=1 时附带每个文件的磁盘路径
+func (s *FileServer) handleUploaderStats(c *fiber.Ctx) error {
+	ip := uploaderIP(c)
+	if ip == "" {
+		return c.Status(400).SendString("Invalid IP address")
+	}
+	showDiskPath := wantDiskPath(c)
+
+	rows, err := s.db.Query(`
+       SELECT path, filename, encoded_filename, file_size, upload_time, expires_at,
+              COALESCE(storage_dir, path), content IS NOT NULL
+       FROM files
+       WHERE uploader_ip = ?
+       ORDER BY id DESC
+   `, ip)
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	defer rows.Close()
+
+	files := []fiber.Map{}
+	var totalBytes int64
+	for rows.Next() {
+		var path, filename, encodedFilename, storageDir string
+		var fileSize int64
+		var uploadTime time.Time
+		var expiresAt sql.NullTime
+		var inDB bool
+		if err := rows.Scan(&path, &filename, &encodedFilename, &fileSize, &uploadTime, &expiresAt,
+			&storageDir, &inDB); err != nil {
+			return dbUnavailable(c, err)
+		}
+		totalBytes += fileSize
+		file := fiber.Map{
+			"path":       path,
+			"filename":   filename,
+			"url":        fmt.Sprintf("%s/%s/%s", baseURL(c), path, encodedFilename),
+			"size":       fileSize,
+			"uploadTime": uploadTime.Local().Format(timeLayout),
+		}
+		setExpiry(file, expiresAt)
+		if showDiskPath {
+			file["diskPath"] = nullIfEmpty(s.diskPath(storageDir, filename, inDB))
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	return sendJSON(c, fiber.Map{
+		"ip":         ip,
+		"fileCount":  len(files),
+		"totalBytes": totalBytes,
+		"files":      files,
+	})
+}
+
+// handleUploaderDelete 删除某个 IP 上传的全部文件，用于快速处置滥用；
+// 逐个文件走与删除码删除相同的流程，部分失败时返回 207
+func (s *FileServer) handleUploaderDelete(c *fiber.Ctx) error {
+	ip := uploaderIP(c)
+	if ip == "" {
+		return c.Status(400).SendString("Invalid IP address")
+	}
+
+	rows, err := s.db.Query("SELECT path, filename, encoded_filename, delete_code FROM files WHERE uploader_ip = ?", ip)
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	type ipFile struct{ path, filename, encodedFilename, deleteCode string }
+	var targets []ipFile
+	for rows.Next() {
+		var f ipFile
+		if err := rows.Scan(&f.path, &f.filename, &f.encodedFilename, &f.deleteCode); err != nil {
+			rows.Close()
+			return dbUnavailable(c, err)
+		}
+		targets = append(targets, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	results := make([]fiber.Map, len(targets))
+	removed := 0
+	for i, f := range targets {
+		item := fiber.Map{"path": f.path, "filename": f.filename}
+		if err := s.deleteFile(f.path, f.encodedFilename, f.deleteCode, "", false); err != nil {
+			results[i] = batchItemError(item, err)
+			continue
+		}
+		item["status"] = 200
+		results[i] = item
+		removed++
+	}
+	log.Printf("Admin removed %d of %d files uploaded from %s", removed, len(targets), ip)
+
+	return sendJSON(c.Status(batchStatus(results, 200)), fiber.Map{
+		"ip":      ip,
+		"results": results,
+	})
+}
+
+// handleUploadsStatus 返回上传当前是否开放
+func (s *FileServer) handleUploadsStatus(c *fiber.Ctx) error {
+	return sendJSON(c, fiber.Map{"enabled": !s.uploadsDisabled.Load()})
+}
+
+// handleUploadsToggle 在运行时开放或暂停上传，状态只保存在内存中，重启后恢复开放
+func (s *FileServer) handleUploadsToggle(enabled bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// 之前的状态与目标相反时才记录日志
+		if wasDisabled := s.uploadsDisabled.Swap(!enabled); wasDisabled == enabled {
+			state := "enabled"
+			if !enabled {
+				state = "disabled"
+			}
+			log.Printf("Uploads %s by admin", state)
+		}
+		return sendJSON(c, fiber.Map{"enabled": enabled})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// prefersJSON 判断客户端是否通过 Accept 明确要求 JSON (且不优先接受 HTML)，
+// 未携带 Accept 或为 */* 时返回 false
+func prefersJSON(c *fiber.Ctx) bool {
+	if c.Get(fiber.HeaderAccept) == "" {
+		return false
+	}
+	return c.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON
+}
+
+// apiEndpoint 首页 JSON 中列出的一个公开接口
+type apiEndpoint struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Description string `json:"description"`
+}
+
+// apiEndpoints 当前配置下开放的公开接口，管理接口不列出
+func (s *FileServer) apiEndpoints() []apiEndpoint {
+	endpoints := []apiEndpoint{
+		{"PUT", "/{filename}", "Upload a file as the raw request body"},
+		{"POST", "/{filename}", "Upload a file as the raw request body or as multipart/form-data"},
+		{"HEAD", "/{filename}", "Report the upload methods and limits in response headers"},
+		{"GET", "/{path}/{filename}", "Download a file"},
+		{"GET", "/preview/{path}/{filename}", "Preview a file in the browser"},
+		{"GET", "/thumbnail/{path}/{filename}", "Poster frame of a video, when one was generated"},
+		{"GET", "/owner/{path}/{filename}", "Show file metadata, requires the delete code"},
+		{"DELETE", "/delete/{path}/{filename}", "Delete a file, requires the delete code"},
+		{"POST", "/delete", "Delete several files at once"},
+		{"GET", "/health", "Health check"},
+	}
+	if s.config.UploadChallenge != "none" {
+		endpoints = append(endpoints, apiEndpoint{"GET", "/challenge", "Get an upload challenge"})
+	}
+	if s.config.ShortLinks {
+		endpoints = append(endpoints, apiEndpoint{"GET", "/d/{id}", "Redirect a short link to its file"})
+	}
+	if s.config.SignedURLSecret != "" {
+		endpoints = append(endpoints, apiEndpoint{"GET", "/s/{token}/{filename}", "Download a file through a signed URL"})
+	}
+	if s.config.FeedEnabled {
+		endpoints = append(endpoints, apiEndpoint{"GET", "/feed", "JSON Feed of recent public uploads"})
+	}
+	if s.config.MetricsEnabled {
+		endpoints = append(endpoints, apiEndpoint{"GET", "/metrics", "Prometheus metrics"})
+	}
+	return endpoints
+}
+
+// handleAPIDescription 向 Accept: application/json 的客户端返回接口与限制说明，便于程序发现
+func (s *FileServer) handleAPIDescription(c *fiber.Ctx) error {
+	limits := fiber.Map{
+		"maxFileSize":          s.config.MaxFileSize,
+		"maxDescriptionLength": s.config.MaxDescriptionLength,
+		"retentionSeconds":     int64(s.config.DefaultExpiry.Seconds()),
+		"maxRetentionSeconds":  int64(s.config.MaxExpiry.Seconds()),
+		"uploadChallenge":      s.config.UploadChallenge,
+		"requireUploadToken":   s.config.RequireUploadToken,
+		"pathReuse":            s.config.PathReuse,
+		"uploadsEnabled":       !s.uploadsDisabled.Load(),
+	}
+	if s.config.StorageQuotaBytes > 0 {
+		limits["storageQuotaBytes"] = s.config.StorageQuotaBytes
+	}
+	if s.config.MaxDownloadsPerFile > 0 {
+		limits["maxDownloadsPerFile"] = s.config.MaxDownloadsPerFile
+	}
+	if s.config.IdempotencyWindow > 0 {
+		limits["idempotencyWindowSeconds"] = int64(s.config.IdempotencyWindow.Seconds())
+	}
+
+	c.Set("Cache-Control", "no-store")
+	return sendJSON(c, fiber.Map{
+		"baseUrl":    baseURL(c),
+		"serverTime": time.Now().Format(time.RFC3339),
+		"endpoints":  s.apiEndpoints(),
+		"limits":     limits,
+	})
+}
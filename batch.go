@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// batchItemError 批量操作中失败条目的结果，status 与单个请求失败时的状态码一致
+func batchItemError(item fiber.Map, err error) fiber.Map {
+	if e, ok := err.(*fiber.Error); ok {
+		item["status"] = e.Code
+		item["error"] = e.Message
+	} else {
+		log.Printf("Database error: %v", err)
+		item["status"] = 503
+		item["error"] = "Service temporarily unavailable"
+	}
+	return item
+}
+
+// batchStatus 批量操作全部成功时返回 okStatus，只要有条目失败就返回 207 Multi-Status
+func batchStatus(items []fiber.Map, okStatus int) int {
+	for _, item := range items {
+		if status, _ := item["status"].(int); status >= 300 {
+			return 207
+		}
+	}
+	return okStatus
+}
+
+// batchDeleteRequest 批量删除的请求体
+type batchDeleteRequest struct {
+	Files []struct {
+		Path         string `json:"path"`
+		Filename     string `json:"filename"`
+		DeleteCode   string `json:"deleteCode"`
+		ConfirmToken string `json:"confirmToken"`
+	} `json:"files"`
+}
+
+// handleBatchDelete 一次删除多个文件，每个条目单独返回结果，部分失败时返回 207
+func (s *FileServer) handleBatchDelete(c *fiber.Ctx) error {
+	var req batchDeleteRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).SendString("Invalid JSON body")
+	}
+	if len(req.Files) == 0 {
+		return c.Status(400).SendString("No files specified")
+	}
+	if max := s.config.MaxFilesPerRequest; len(req.Files) > max {
+		return c.Status(400).SendString(fmt.Sprintf("Too many files in request, maximum is %d", max))
+	}
+
+	requireConfirm := s.config.DeleteConfirmation && !isTextPreferred(c)
+	results := make([]fiber.Map, len(req.Files))
+	for i, f := range req.Files {
+		item := fiber.Map{"path": f.Path, "filename": f.Filename}
+		filename, ok := safeRequestFilename(f.Filename)
+		switch {
+		case !ok:
+			results[i] = batchItemError(item, fiber.NewError(400, "Invalid filename"))
+		case f.Path == "":
+			results[i] = batchItemError(item, fiber.NewError(404, "File not found"))
+		case f.DeleteCode == "":
+			results[i] = batchItemError(item, fiber.NewError(401, "Delete code required"))
+		default:
+			err := s.deleteFile(f.Path, url.QueryEscape(filename), f.DeleteCode, f.ConfirmToken, requireConfirm)
+			if err != nil {
+				results[i] = batchItemError(item, err)
+			} else {
+				item["status"] = 200
+				results[i] = item
+			}
+		}
+	}
+
+	return sendJSON(c.Status(batchStatus(results, 200)), fiber.Map{"results": results})
+}
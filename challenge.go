@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 工作量证明题目的有效期
+const powChallengeTTL = 10 * time.Minute
+
+// powReplayGuard 记录已使用的题目，防止同一个解被重复用于多次上传
+type powReplayGuard struct {
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+func newPowReplayGuard() *powReplayGuard {
+	return &powReplayGuard{used: make(map[string]time.Time)}
+}
+
+// Use 标记题目已使用，已用过时返回 false
+func (g *powReplayGuard) Use(challenge string, expires time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	for k, exp := range g.used {
+		if now.After(exp) {
+			delete(g.used, k)
+		}
+	}
+	if _, ok := g.used[challenge]; ok {
+		return false
+	}
+	g.used[challenge] = expires
+	return true
+}
+
+// newPowChallenge 生成题目，格式为 "<过期时间戳>.<随机串>.<HMAC>"，服务端无需保存
+func (s *FileServer) newPowChallenge() string {
+	payload := strconv.FormatInt(time.Now().Add(powChallengeTTL).Unix(), 10) + "." + generateRandomString(16)
+	return payload + "." + s.signPow(payload)
+}
+
+func (s *FileServer) signPow(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("pow\n" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyPow 校验 X-PoW 头 "<题目>:<nonce>"，要求 sha256("<题目>:<nonce>") 前 N 位为 0
+func (s *FileServer) verifyPow(header string) error {
+	challenge, nonce, ok := strings.Cut(header, ":")
+	if !ok || nonce == "" {
+		return fmt.Errorf("Upload challenge required, get one from /challenge")
+	}
+
+	idx := strings.LastIndex(challenge, ".")
+	if idx < 0 || !hmac.Equal([]byte(challenge[idx+1:]), []byte(s.signPow(challenge[:idx]))) {
+		return fmt.Errorf("Invalid upload challenge")
+	}
+	exp, _, _ := strings.Cut(challenge, ".")
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return fmt.Errorf("Upload challenge expired")
+	}
+
+	sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+	if leadingZeroBits(sum[:]) < s.config.PowDifficulty {
+		return fmt.Errorf("Invalid upload challenge solution")
+	}
+	if !s.powGuard.Use(challenge, time.Unix(unix, 0)) {
+		return fmt.Errorf("Upload challenge already used")
+	}
+	return nil
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, v := range b {
+		if v != 0 {
+			return n + bits.LeadingZeros8(v)
+		}
+		n += 8
+	}
+	return n
+}
+
+// verifyCaptcha 将 X-Captcha-Token 提交到 hCaptcha/Turnstile 兼容的校验接口
+func (s *FileServer) verifyCaptcha(c *fiber.Ctx) error {
+	token := c.Get("X-Captcha-Token")
+	if token == "" {
+		return fmt.Errorf("Captcha token required")
+	}
+
+	form := url.Values{
+		"secret":   {s.config.CaptchaSecret},
+		"response": {token},
+		"remoteip": {clientIP(c)},
+	}
+	req, err := http.NewRequest(http.MethodPost, s.config.CaptchaVerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("Captcha verification unavailable")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.outbound.Do(req)
+	if err != nil {
+		return fmt.Errorf("Captcha verification unavailable")
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || !result.Success {
+		return fmt.Errorf("Captcha verification failed")
+	}
+	return nil
+}
+
+// checkUploadChallenge 按配置校验上传前的反滥用挑战，未开启时直接通过
+func (s *FileServer) checkUploadChallenge(c *fiber.Ctx) error {
+	switch s.config.UploadChallenge {
+	case "pow":
+		return s.verifyPow(c.Get("X-PoW"))
+	case "captcha":
+		return s.verifyCaptcha(c)
+	}
+	return nil
+}
+
+// handleChallenge 下发工作量证明题目
+func (s *FileServer) handleChallenge(c *fiber.Ctx) error {
+	if s.config.UploadChallenge != "pow" {
+		return c.Status(404).SendString("Upload challenge not enabled")
+	}
+	c.Set("Cache-Control", "no-store")
+	return sendJSON(c, fiber.Map{
+		"challenge":  s.newPowChallenge(),
+		"difficulty": s.config.PowDifficulty,
+		"expiresIn":  int(powChallengeTTL.Seconds()),
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// RFC 3230 Digest 头中使用的算法名称
+var digestNames = map[string]string{
+	"md5":    "MD5",
+	"sha1":   "SHA",
+	"sha256": "SHA-256",
+}
+
+// checksumWriter 在一次写入过程中同时计算多个校验值
+type checksumWriter struct {
+	algorithms []string
+	hashes     []hash.Hash
+}
+
+func newChecksumWriter(algorithms []string) *checksumWriter {
+	w := &checksumWriter{algorithms: algorithms}
+	for _, alg := range algorithms {
+		w.hashes = append(w.hashes, checksumAlgorithms[alg]())
+	}
+	return w
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	for _, h := range w.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// Sums 返回各算法的十六进制校验值
+func (w *checksumWriter) Sums() map[string]string {
+	sums := make(map[string]string, len(w.hashes))
+	for i, h := range w.hashes {
+		sums[w.algorithms[i]] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums
+}
+
+// digestHeader 按 RFC 3230 生成 Digest 头，例如 "MD5=...,SHA-256=..."
+func digestHeader(sums map[string]string) string {
+	var parts []string
+	for _, alg := range []string{"md5", "sha1", "sha256"} {
+		sum, ok := sums[alg]
+		if !ok || sum == "" {
+			continue
+		}
+		raw, err := hex.DecodeString(sum)
+		if err != nil {
+			continue
+		}
+		parts = append(parts, digestNames[alg]+"="+base64.StdEncoding.EncodeToString(raw))
+	}
+	return strings.Join(parts, ",")
+}
+
+// contentMD5 返回 Content-MD5 头的值 (base64 编码)
+func contentMD5(md5Hex string) string {
+	raw, err := hex.DecodeString(md5Hex)
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// 下载时边发送边计算的 SHA-256，作为 HTTP 尾部字段在内容之后发送
+const checksumTrailer = "X-Checksum-Sha256"
+
+// checksumTrailerRequested 客户端通过 TE: trailers 或 ?checksum=trailer 要求在响应尾部附带校验值
+func checksumTrailerRequested(c *fiber.Ctx) bool {
+	if c.Query("checksum") == "trailer" {
+		return true
+	}
+	for _, te := range strings.Split(c.Get("TE"), ",") {
+		if strings.EqualFold(strings.TrimSpace(te), "trailers") {
+			return true
+		}
+	}
+	return false
+}
+
+// trailerChecksumReader 对实际发送的内容计算 SHA-256，读到末尾时写入响应的尾部字段；
+// 无法一次读完再校验的客户端可在接收的同时计算并比对
+type trailerChecksumReader struct {
+	r      io.Reader
+	h      hash.Hash
+	header *fasthttp.ResponseHeader
+}
+
+func newTrailerChecksumReader(r io.Reader, header *fasthttp.ResponseHeader) io.Reader {
+	header.SetTrailer(checksumTrailer)
+	return &trailerChecksumReader{r: r, h: sha256.New(), header: header}
+}
+
+func (t *trailerChecksumReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.h.Write(p[:n])
+	if err == io.EOF {
+		t.header.Set(checksumTrailer, hex.EncodeToString(t.h.Sum(nil)))
+	}
+	return n, err
+}
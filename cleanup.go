@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// cleanupResult 单次过期清理的汇总
+type cleanupResult struct {
+	StartedAt    time.Time `json:"startedAt"`
+	DurationMs   int64     `json:"durationMs"`
+	FilesRemoved int       `json:"filesRemoved"`
+	BytesFreed   int64     `json:"bytesFreed"`
+	// 删除的未完成上传 (中断残留的临时文件) 数
+	IncompleteRemoved int `json:"incompleteRemoved"`
+	// 删除的没有对应记录的空文件数
+	EmptyOrphansRemoved int      `json:"emptyOrphansRemoved"`
+	Errors              []string `json:"errors"`
+}
+
+func (s *FileServer) cleanupExpiredFiles() error {
+	result := &cleanupResult{StartedAt: time.Now(), Errors: []string{}}
+
+	err := s.removeExpiredFiles(result)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+	}
+	s.removeStaleTempFiles(result)
+	if s.config.RemoveEmptyOrphans {
+		s.removeEmptyOrphans(result)
+	}
+	result.DurationMs = time.Since(result.StartedAt).Milliseconds()
+
+	s.cleanupMu.Lock()
+	s.lastCleanup = result
+	s.cleanupMu.Unlock()
+	s.cleanupStats.record(result)
+
+	summary, _ := json.Marshal(result)
+	s.cleanupLog.Printf("Cleanup finished: %s", summary)
+
+	return err
+}
+
+// expiredFile 待清理的过期文件记录
+type expiredFile struct {
+	id         int64
+	path       string
+	filename   string
+	storageDir string
+	fileSize   int64
+}
+
+// removeExpiredFiles 按批次清理过期文件，每批之间短暂停顿，避免长时间占用写锁阻塞上传；
+// 每批先用一条语句删除仍然过期的记录，再并发删除这些记录对应的磁盘文件
+func (s *FileServer) removeExpiredFiles(result *cleanupResult) error {
+	cutoff := time.Now().UTC().Format(timeLayout)
+
+	for batch := 1; ; batch++ {
+		files, err := s.expiredBatch(cutoff)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return nil
+		}
+
+		deleted, err := s.deleteExpiredRecords(files)
+		if err != nil {
+			return err
+		}
+		s.removeExpiredBatchFiles(deleted, result)
+
+		var freedBytes int64
+		for _, f := range deleted {
+			freedBytes += f.fileSize
+		}
+		s.quota.Free(freedBytes)
+		result.FilesRemoved += len(deleted)
+		result.BytesFreed += freedBytes
+		s.cleanupLog.Printf("Cleanup batch %d: removed %d files (%d bytes)", batch, len(deleted), freedBytes)
+
+		if len(files) < s.config.CleanupBatchSize {
+			return nil
+		}
+		time.Sleep(s.config.CleanupBatchPause)
+	}
+}
+
+// deleteExpiredRecords 删除一批记录中删除时仍然过期的记录，返回实际删除的记录；
+// 读取批次之后被滑动过期延长或被幂等上传替换的记录不再过期，保留记录及其文件
+func (s *FileServer) deleteExpiredRecords(files []expiredFile) ([]expiredFile, error) {
+	byID := make(map[int64]expiredFile, len(files))
+	ids := make([]interface{}, len(files))
+	for i, f := range files {
+		byID[f.id] = f
+		ids[i] = f.id
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	rows, err := s.db.Query("DELETE FROM files WHERE id IN ("+placeholders+") AND expires_at < datetime('now') RETURNING id", ids...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired records: %v", err)
+	}
+	defer rows.Close()
+
+	var deleted []expiredFile
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to read deleted record: %v", err)
+		}
+		deleted = append(deleted, byID[id])
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to delete expired records: %v", err)
+	}
+	return deleted, nil
+}
+
+// removeExpiredBatchFiles 用最多 CLEANUP_CONCURRENCY 个 goroutine 删除一批过期文件及其空目录，
+// 网络文件系统上逐个删除的往返延迟可以相互重叠
+func (s *FileServer) removeExpiredBatchFiles(files []expiredFile, result *cleanupResult) {
+	workers := s.config.CleanupConcurrency
+	if workers > len(files) {
+		workers = len(files)
+	}
+
+	jobs := make(chan expiredFile)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				filePath := filepath.Join(s.uploadDir, f.storageDir, f.filename)
+				if err := s.removeStoredFile(f.path, f.storageDir, filePath); err != nil {
+					mu.Lock()
+					s.cleanupError(result, "Failed to delete file %s: %v", filePath, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, f := range files {
+		jobs <- f
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// expiredBatch 读取一批过期记录，读取完毕后立即释放游标，再执行删除
+func (s *FileServer) expiredBatch(cutoff string) ([]expiredFile, error) {
+	rows, err := s.db.Query(`
+       SELECT id, path, filename, COALESCE(stored_size, file_size), COALESCE(storage_dir, path)
+       FROM files
+       WHERE expires_at < ?
+       ORDER BY id
+       LIMIT ?
+   `, cutoff, s.config.CleanupBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expired files: %v", err)
+	}
+	defer rows.Close()
+
+	var files []expiredFile
+	for rows.Next() {
+		var f expiredFile
+		if err := rows.Scan(&f.id, &f.path, &f.filename, &f.fileSize, &f.storageDir); err != nil {
+			return nil, fmt.Errorf("failed to read file record: %v", err)
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// removeStaleTempFiles 删除超过 INCOMPLETE_UPLOAD_TIMEOUT 仍未完成的上传临时文件，
+// 这些文件是进程中断或连接断开后的残留，对应的配额预留已随请求结束释放
+func (s *FileServer) removeStaleTempFiles(result *cleanupResult) {
+	filepath.WalkDir(s.uploadDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasPrefix(d.Name(), tempFilePrefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || time.Since(info.ModTime()) < s.config.IncompleteUploadTimeout {
+			return nil
+		}
+		if err := os.Remove(filePath); err != nil {
+			if !os.IsNotExist(err) {
+				s.cleanupError(result, "Failed to delete stale temp file %s: %v", filePath, err)
+			}
+			return nil
+		}
+		result.IncompleteRemoved++
+		return nil
+	})
+}
+
+// removeEmptyOrphans 删除上传目录中没有对应记录的空文件，例如写入后入库失败或进程中断留下的文件；
+// 记录先于文件出现，持有路径锁检查可避免误删正在完成的上传
+func (s *FileServer) removeEmptyOrphans(result *cleanupResult) {
+	var candidates []string
+	filepath.WalkDir(s.uploadDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || strings.HasPrefix(d.Name(), tempFilePrefix) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Size() == 0 {
+			candidates = append(candidates, filePath)
+		}
+		return nil
+	})
+
+	for _, filePath := range candidates {
+		storageDir, err := filepath.Rel(s.uploadDir, filepath.Dir(filePath))
+		if err != nil || storageDir == "." {
+			continue
+		}
+		storageDir = filepath.ToSlash(storageDir)
+		path := filepath.Base(storageDir)
+		filename := filepath.Base(filePath)
+
+		unlock := s.pathLocks.Lock(path)
+		var referenced int
+		err = s.db.QueryRow("SELECT COUNT(*) FROM files WHERE COALESCE(storage_dir, path) = ? AND filename = ?",
+			storageDir, filename).Scan(&referenced)
+		if err != nil || referenced > 0 {
+			unlock()
+			if err != nil {
+				s.cleanupError(result, "Failed to look up empty file %s: %v", filePath, err)
+			}
+			continue
+		}
+		err = os.Remove(filePath)
+		unlock()
+		if err != nil {
+			if !os.IsNotExist(err) {
+				s.cleanupError(result, "Failed to remove empty orphan file %s: %v", filePath, err)
+			}
+			continue
+		}
+		s.cleanupLog.Printf("Removed empty orphan file %s", filePath)
+		result.EmptyOrphansRemoved++
+		s.removeDirIfEmpty(path, storageDir)
+	}
+}
+
+func (s *FileServer) cleanupError(result *cleanupResult, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	s.cleanupLog.Print(msg)
+	result.Errors = append(result.Errors, msg)
+}
+
+// handleCleanupStatus 返回最近一次清理的结果
+func (s *FileServer) handleCleanupStatus(c *fiber.Ctx) error {
+	s.cleanupMu.Lock()
+	defer s.cleanupMu.Unlock()
+	return sendJSON(c, fiber.Map{"lastRun": s.lastCleanup})
+}
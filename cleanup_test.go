@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newCleanupTestServer(tb testing.TB, concurrency int) *FileServer {
+	tb.Helper()
+	return &FileServer{
+		db:         newTestDB(tb),
+		uploadDir:  tb.TempDir(),
+		config:     &Config{CleanupBatchSize: 500, CleanupConcurrency: concurrency},
+		quota:      newStorageQuota(0, 0),
+		pathLocks:  newKeyedMutex(),
+		readers:    newFileReaders(),
+		cleanupLog: log.New(os.Stderr, "", 0),
+	}
+}
+
+// addCleanupTestFile 写入一个文件及其记录，expiresIn 为负时记录已过期
+func addCleanupTestFile(tb testing.TB, s *FileServer, path string, expiresIn time.Duration) string {
+	tb.Helper()
+	filePath := filepath.Join(s.uploadDir, path, "file.txt")
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, []byte("content"), 0644); err != nil {
+		tb.Fatal(err)
+	}
+	expiresAt := time.Now().UTC().Add(expiresIn).Format(timeLayout)
+	if _, err := s.db.Exec(`
+       INSERT INTO files (path, filename, encoded_filename, delete_code, upload_time, file_size, expires_at)
+       VALUES (?, 'file.txt', 'file.txt', 'code', datetime('now'), 7, ?)
+   `, path, expiresAt); err != nil {
+		tb.Fatal(err)
+	}
+	return filePath
+}
+
+func TestRemoveExpiredFiles(t *testing.T) {
+	s := newCleanupTestServer(t, 4)
+	expired := addCleanupTestFile(t, s, "expired", -time.Hour)
+	live := addCleanupTestFile(t, s, "live", time.Hour)
+
+	result := &cleanupResult{}
+	if err := s.removeExpiredFiles(result); err != nil {
+		t.Fatal(err)
+	}
+	if result.FilesRemoved != 1 || result.BytesFreed != 7 {
+		t.Errorf("removed %d files (%d bytes), want 1 (7 bytes)", result.FilesRemoved, result.BytesFreed)
+	}
+	if fileExists(expired) || fileExists(filepath.Dir(expired)) {
+		t.Error("expired file or its directory still exists")
+	}
+	if !fileExists(live) {
+		t.Error("live file removed")
+	}
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM files").Scan(&count)
+	if count != 1 {
+		t.Errorf("%d records left, want 1", count)
+	}
+}
+
+// TestDeleteExpiredRecordsExtended 读取批次后记录被延长 (滑动过期或替换上传)，
+// 记录与文件都必须保留
+func TestDeleteExpiredRecordsExtended(t *testing.T) {
+	s := newCleanupTestServer(t, 4)
+	expired := addCleanupTestFile(t, s, "expired", -time.Hour)
+	extended := addCleanupTestFile(t, s, "extended", -time.Hour)
+
+	files, err := s.expiredBatch(time.Now().UTC().Format(timeLayout))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("expiredBatch = %d files, %v, want 2", len(files), err)
+	}
+	if _, err := s.db.Exec("UPDATE files SET expires_at = datetime('now', '+1 hour') WHERE path = 'extended'"); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := s.deleteExpiredRecords(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].path != "expired" {
+		t.Fatalf("deleted %+v, want only the expired record", deleted)
+	}
+	result := &cleanupResult{}
+	s.removeExpiredBatchFiles(deleted, result)
+	if fileExists(expired) {
+		t.Error("expired file still exists")
+	}
+	if !fileExists(extended) {
+		t.Error("file of the extended record removed")
+	}
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM files WHERE path = 'extended'").Scan(&count)
+	if count != 1 {
+		t.Error("extended record deleted")
+	}
+}
+
+// BenchmarkRemoveExpiredBatchFiles 比较不同 CLEANUP_CONCURRENCY 下删除一批 500 个文件的耗时
+func BenchmarkRemoveExpiredBatchFiles(b *testing.B) {
+	for _, concurrency := range []int{1, 4, 8, 16} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			s := newCleanupTestServer(b, concurrency)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				files := make([]expiredFile, 500)
+				for j := range files {
+					path := fmt.Sprintf("f%d-%d", i, j)
+					filePath := filepath.Join(s.uploadDir, path, "file.txt")
+					if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+						b.Fatal(err)
+					}
+					if err := os.WriteFile(filePath, []byte("content"), 0644); err != nil {
+						b.Fatal(err)
+					}
+					files[j] = expiredFile{path: path, filename: "file.txt", storageDir: path, fileSize: 7}
+				}
+				b.StartTimer()
+				s.removeExpiredBatchFiles(files, &cleanupResult{})
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// isCompressibleType 只压缩文本类文件，图片、音视频、压缩包等二进制类型本身已难以压缩
+func isCompressibleType(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-ndjson",
+		"application/yaml", "application/x-yaml", "application/sql":
+		return true
+	}
+	return false
+}
+
+// isGzipData 判断内容是否以 gzip 魔数开头，恢复记录时用于识别压缩存储的文本文件
+func isGzipData(head []byte) bool {
+	return len(head) >= 2 && head[0] == 0x1f && head[1] == 0x8b
+}
+
+// forwardSeeker 让解压流满足 serveContent 需要的 io.ReadSeeker，
+// 只支持向前定位，跳过的部分解压后丢弃
+type forwardSeeker struct {
+	r   io.Reader
+	pos int64
+}
+
+func (f *forwardSeeker) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *forwardSeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart || offset < f.pos {
+		return f.pos, errors.New("forwardSeeker: only forward seeks are supported")
+	}
+	n, err := io.CopyN(io.Discard, f.r, offset-f.pos)
+	f.pos += n
+	return f.pos, err
+}
+
+// serveCompressed 发送以 gzip 存储的文件：客户端支持 gzip 且不是 Range 请求时原样发送并设置
+// Content-Encoding，否则边解压边发送，Range 按原始内容计算；size 为原始大小
+func serveCompressed(c *fiber.Ctx, stored io.ReadSeeker, closer io.Closer, storedSize, size int64, modTime time.Time,
+	name, mimeType string, onDone func(sent int64)) error {
+	c.Vary(fiber.HeaderAcceptEncoding)
+	// 要求尾部校验值时解压后发送，校验值与客户端收到的原始内容一致
+	if c.Get(fiber.HeaderRange) == "" && c.Context().Request.Header.HasAcceptEncoding("gzip") && !checksumTrailerRequested(c) {
+		c.Set(fiber.HeaderContentEncoding, "gzip")
+		// Content-MD5 与 Digest 按原始内容计算，与发送的 gzip 字节不符，不发送
+		c.Response().Header.Del("Content-MD5")
+		c.Response().Header.Del("Digest")
+		// 下载计数按原始大小判断是否完整发送
+		return serveContent(c, stored, closer, storedSize, modTime, name, mimeType, func(sent int64) {
+			if sent >= storedSize {
+				sent = size
+			}
+			onDone(sent)
+		})
+	}
+
+	return serveDecompressed(c, stored, closer, size, modTime, name, mimeType, onDone)
+}
+
+// serveDecompressed 边解压边发送以 gzip 存储的内容，size 为要发送的原始内容长度
+func serveDecompressed(c *fiber.Ctx, stored io.Reader, closer io.Closer, size int64, modTime time.Time,
+	name, mimeType string, onDone func(sent int64)) error {
+	zr, err := gzip.NewReader(stored)
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		onDone(0)
+		return err
+	}
+	return serveContent(c, &forwardSeeker{r: zr}, closer, size, modTime, name, mimeType, onDone)
+}
+
+// serveCompressedFile 打开以 gzip 存储在磁盘上的文件并发送，size 为原始大小；
+// 磁盘上的字节数与记录的存储大小 storedSize 不一致时返回 errSizeMismatch，storedSize 为 0 时不核对
+func serveCompressedFile(c *fiber.Ctx, filePath string, storedSize, size int64, mimeType string, onDone func(sent int64)) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		onDone(0)
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		err = checkStoredSize(storedSize, info.Size())
+	}
+	if err != nil {
+		f.Close()
+		onDone(0)
+		return err
+	}
+	return serveCompressed(c, f, f, info.Size(), size, info.ModTime(), filePath, mimeType, onDone)
+}
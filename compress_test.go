@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+	"time"
+)
+
+func TestServeCompressedChecksumHeaders(t *testing.T) {
+	content := []byte("hello, compressed world\n")
+	var stored bytes.Buffer
+	zw := gzip.NewWriter(&stored)
+	zw.Write(content)
+	zw.Close()
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		encoding string
+		body     []byte
+	}{
+		{"gzip", map[string]string{"Accept-Encoding": "gzip"}, "gzip", stored.Bytes()},
+		{"identity", nil, "", content},
+	}
+	for _, tt := range tests {
+		c := newTestCtx(t, "GET", "/abcd/file.txt", tt.headers)
+		c.Set("Content-MD5", "original-md5")
+		c.Set("Digest", "sha-256=original")
+
+		err := serveCompressed(c, bytes.NewReader(stored.Bytes()), nil, int64(stored.Len()), int64(len(content)),
+			time.Now(), "file.txt", "text/plain", func(int64) {})
+		if err != nil {
+			t.Fatalf("%s: serveCompressed: %v", tt.name, err)
+		}
+		h := &c.Response().Header
+		if got := string(h.Peek("Content-Encoding")); got != tt.encoding {
+			t.Errorf("%s: Content-Encoding = %q, want %q", tt.name, got, tt.encoding)
+		}
+		if !bytes.Equal(c.Response().Body(), tt.body) {
+			t.Errorf("%s: body differs from the expected bytes", tt.name)
+		}
+		// 校验值只在发送原始内容时保留
+		keep := tt.encoding == ""
+		if got := len(h.Peek("Content-MD5")) > 0; got != keep {
+			t.Errorf("%s: Content-MD5 present = %v, want %v", tt.name, got, keep)
+		}
+		if got := len(h.Peek("Digest")) > 0; got != keep {
+			t.Errorf("%s: Digest present = %v, want %v", tt.name, got, keep)
+		}
+	}
+}
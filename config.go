@@ -0,0 +1,593 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config 服务运行配置，全部通过环境变量提供
+type Config struct {
+	// 管理接口密钥，为空时关闭所有管理接口
+	AdminKey string
+	// 上传是否必须携带未吊销的上传令牌 (X-Upload-Token)
+	RequireUploadToken bool
+	// 签名下载链接的密钥，设置后上传结果附带无需查询数据库即可下载的签名链接
+	SignedURLSecret string
+	// 上传时计算并保存的校验算法 (md5, sha1, sha256)
+	ChecksumAlgorithms []string
+	// 非命令行客户端删除时是否必须携带确认令牌
+	DeleteConfirmation bool
+	// 删除已不存在的文件时是否视为成功，使重试的删除请求幂等
+	IdempotentDelete bool
+	// 客户端自定义删除码的最小长度
+	DeleteCodeMinLength int
+	// 客户端自定义删除码至少需包含的字符种类数 (小写、大写、数字、符号)
+	DeleteCodeMinClasses int
+	// 所有文件合计可占用的存储空间，0 表示不限制
+	StorageQuotaBytes int64
+	// 磁盘剩余空间低于任一阈值时拒绝上传，0 表示不检查
+	MinFreeBytes   int64
+	MinFreePercent int
+	// 单个文件的最大字节数，0 表示不限制 (仍受存储配额约束)
+	MaxFileSize int64
+	// 预览接口返回的最大字节数
+	PreviewMaxBytes int64
+	// 是否在服务端装有 ffmpeg 时为视频生成封面
+	VideoPosters bool
+	// ffmpeg 可执行文件名或路径
+	FFmpegPath string
+	// 浏览器缓存文件信息页的最长时间，不超过文件剩余的保留时间，0 表示不缓存
+	LandingPageMaxAge time.Duration
+	// 下载时发现记录存在而磁盘文件缺失，是否删除该记录
+	RemoveDanglingRecords bool
+	// 不超过该大小的文件直接以 BLOB 存入数据库而不写磁盘，0 表示关闭
+	BlobMaxSize int64
+	// 下载时精确匹配失败后是否忽略文件名大小写再次查找
+	CaseInsensitiveDownload bool
+	// 路径风格：random 为 4 位随机字符，words 为三个单词组成的易读路径
+	PathStyle string
+	// 上传目录布局：flat 为 uploads/abcd/，sharded 为 uploads/ab/cd/abcd/，date 为 uploads/2024/01/15/abcd/
+	StorageLayout string
+	// multipart 上传接受的文件字段名，按顺序查找，* 表示任意字段
+	UploadFieldNames []string
+	// 没有登记 MIME 类型、需按 text/plain 保存以便浏览器直接显示的扩展名
+	TextExtensions []string
+	// 下载时在浏览器中直接打开的 MIME 类型前缀，其余类型作为附件下载
+	InlineTypes []string
+	// URL 路径与 Content-Disposition 同时给出文件名时以哪个为准：url 或 header
+	FilenamePrecedence string
+	// 单个 multipart 请求中允许的最大文件数
+	MaxFilesPerRequest int
+	// X-Description 文件说明允许的最大字符数
+	MaxDescriptionLength int
+	// 下载计数方式：request 为除 HEAD 与探测性 Range 外的每次 GET，
+	// complete 为完整发送整个文件，all 为每次 GET (HEAD 始终不计)
+	DownloadCountMode string
+	// 上传前的反滥用挑战：none、pow (工作量证明) 或 captcha
+	UploadChallenge string
+	// 工作量证明要求哈希前导零的位数
+	PowDifficulty int
+	// hCaptcha/Turnstile 兼容的服务端校验地址与密钥
+	CaptchaVerifyURL string
+	CaptchaSecret    string
+	// 单个文件允许的并发下载数，0 表示不限制
+	MaxDownloadsPerFile int
+	// 同一 IP 允许的并发连接数，超出时新连接收到 429 后被关闭，0 表示不限制
+	MaxConnsPerIP int
+	// 整个服务每秒允许的请求数 (0 表示不限制) 及可瞬时超出的请求数
+	GlobalRateLimit int
+	GlobalRateBurst int
+	// 记住不存在的文件的时长 (0 表示不缓存) 及最多记住的条目数
+	NotFoundCacheTTL  time.Duration
+	NotFoundCacheSize int
+	// 来自可信代理的请求从该头读取客户端 IP，为空时始终使用连接的对端地址
+	ProxyHeader string
+	// 可信代理的 IP 或 CIDR 网段
+	TrustedProxies []string
+	// 发送整个响应的时限，包括下载的文件内容，0 表示不限制
+	WriteTimeout time.Duration
+	// 服务端发起外部请求的超时、并发上限与代理
+	OutboundTimeout     time.Duration
+	OutboundConcurrency int
+	OutboundProxy       string
+	// 允许引用下载链接的站点 (防盗链)，为空时不限制
+	RefererAllowlist []string
+	// 启用防盗链时是否允许没有 Referer 的直接访问
+	RefererAllowEmpty bool
+	// 是否规范化请求地址 (合并重复斜杠、忽略末尾斜杠)，关闭时路由严格匹配
+	NormalizeURLs bool
+	// 是否允许通过 X-Upload-Path 向已有路径追加文件
+	PathReuse bool
+	// 同一路径下文件名冲突时的处理：reject 返回 409，suffix 自动加编号后缀
+	NameConflict string
+	// 文件名的扩展名与类型不符时的处理：off、warn (记录日志) 或 append (追加正确的扩展名)
+	ExtensionCheck string
+	// suffix 策略的后缀模板，{n} 为从 1 开始的编号，插入在扩展名之前
+	NameConflictSuffix string
+	// 携带相同 Idempotency-Key 的重复上传在该时长内覆盖之前的文件，0 表示不启用
+	IdempotencyWindow time.Duration
+	// 是否为上传返回基于记录 id 的 /d/:id 短链接
+	ShortLinks bool
+	// 未指定过期时间的上传的保留时长
+	DefaultExpiry time.Duration
+	// 上传时通过 X-Expire 或 ?expire= 可指定的最长保留时长
+	MaxExpiry time.Duration
+	// 是否在每次计入的下载后把过期时间顺延为当前时间加保留期
+	SlidingExpiry bool
+	// 是否以 gzip 压缩存储文本类文件
+	CompressText bool
+	// 是否拒绝无法确定类型 (最终为 application/octet-stream) 的上传
+	RequireContentType bool
+	// 是否开放 /feed (最近公开文件的 JSON Feed)
+	FeedEnabled bool
+	// 是否开放 /metrics (Prometheus 格式的存储与清理指标)
+	MetricsEnabled bool
+	// 过期清理每批处理的文件数与批次间的停顿
+	CleanupBatchSize  int
+	CleanupBatchPause time.Duration
+	// 过期清理时并发删除文件的数量
+	CleanupConcurrency int
+	// 上传临时文件超过该时长仍未完成时视为中断残留，由过期清理删除
+	IncompleteUploadTimeout time.Duration
+	// 过期清理时是否删除没有对应记录的空文件
+	RemoveEmptyOrphans bool
+	// 是否拒绝发送经符号链接解析后位于上传目录之外的文件
+	ConfineSymlinks bool
+	// 全量完整性校验时每个文件之间的停顿
+	VerifyPause time.Duration
+	// 清理汇总日志的输出文件，为空时写入标准日志
+	CleanupLogFile string
+	// JSON 上传成功时的状态码 (201 或 200)
+	UploadSuccessStatus int
+	// 上传、下载计数与删除的写操作遇到数据库锁冲突时的重试次数与首次重试前的等待时间
+	DBWriteRetries int
+	DBRetryBackoff time.Duration
+	// 是否以多个进程 (Prefork) 监听同一端口
+	Prefork bool
+	// 同一 IP 对同一文件连续输错下载密码的次数上限，达到后锁定该 IP 对该文件的下载
+	DownloadPasswordMaxAttempts int
+	DownloadPasswordLockout     time.Duration
+}
+
+func loadConfig() (*Config, error) {
+	cfg := &Config{
+		AdminKey:           os.Getenv("ADMIN_KEY"),
+		SignedURLSecret:    os.Getenv("SIGNED_URL_SECRET"),
+		CleanupLogFile:     envString("CLEANUP_LOG_FILE", ""),
+		StorageLayout:      strings.ToLower(envString("STORAGE_LAYOUT", "flat")),
+		PathStyle:          strings.ToLower(envString("PATH_STYLE", "random")),
+		UploadChallenge:    strings.ToLower(envString("UPLOAD_CHALLENGE", "none")),
+		DownloadCountMode:  strings.ToLower(envString("DOWNLOAD_COUNT_MODE", "request")),
+		CaptchaVerifyURL:   envString("CAPTCHA_VERIFY_URL", ""),
+		CaptchaSecret:      os.Getenv("CAPTCHA_SECRET"),
+		OutboundProxy:      envString("OUTBOUND_PROXY", ""),
+		RefererAllowlist:   envList("REFERER_ALLOWLIST", nil),
+		ChecksumAlgorithms: envList("CHECKSUM_ALGORITHMS", []string{"sha256"}),
+		UploadFieldNames:   envListKeepCase("UPLOAD_FIELD_NAMES", []string{"file"}),
+		TextExtensions:     envList("TEXT_EXTENSIONS", []string{".log", ".conf", ".cfg", ".ini", ".env"}),
+		InlineTypes: envList("INLINE_TYPES", []string{"image/png", "image/jpeg", "image/gif", "image/webp",
+			"application/pdf", "text/plain", "audio/", "video/"}),
+		FilenamePrecedence: strings.ToLower(envString("FILENAME_PRECEDENCE", "url")),
+		NameConflict:       strings.ToLower(envString("NAME_CONFLICT", "reject")),
+		ExtensionCheck:     strings.ToLower(envString("EXTENSION_CHECK", "off")),
+		FFmpegPath:         envString("FFMPEG_PATH", "ffmpeg"),
+		ProxyHeader:        envString("PROXY_HEADER", "X-Real-IP"),
+		TrustedProxies:     envList("TRUSTED_PROXIES", []string{"127.0.0.1", "::1", "172.17.0.1", "192.168.1.8"}),
+		NameConflictSuffix: "-{n}",
+	}
+
+	if cfg.StorageLayout != "flat" && cfg.StorageLayout != "sharded" && cfg.StorageLayout != "date" {
+		return nil, fmt.Errorf("STORAGE_LAYOUT must be flat, sharded or date")
+	}
+
+	if cfg.ExtensionCheck != "off" && cfg.ExtensionCheck != "warn" && cfg.ExtensionCheck != "append" {
+		return nil, fmt.Errorf("EXTENSION_CHECK must be off, warn or append")
+	}
+	if cfg.NameConflict != "reject" && cfg.NameConflict != "suffix" {
+		return nil, fmt.Errorf("NAME_CONFLICT must be reject or suffix")
+	}
+	// 后缀可以以空格开头 (如 " ({n})")，不去除首尾空白
+	if v := os.Getenv("NAME_CONFLICT_SUFFIX"); v != "" {
+		cfg.NameConflictSuffix = v
+	}
+	// 后缀必须带编号，且加上后缀的文件名不会被 sanitizeFilename 改动
+	if sample := "file" + strings.ReplaceAll(cfg.NameConflictSuffix, "{n}", "1"); !strings.Contains(cfg.NameConflictSuffix, "{n}") ||
+		sanitizeFilename(sample) != sample {
+		return nil, fmt.Errorf("NAME_CONFLICT_SUFFIX must contain {n} and only characters allowed in filenames")
+	}
+
+	if strings.EqualFold(cfg.ProxyHeader, "none") {
+		cfg.ProxyHeader = ""
+	}
+	for _, proxy := range cfg.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an IP address or CIDR range", proxy)
+			}
+		}
+	}
+
+	if cfg.PathStyle != "random" && cfg.PathStyle != "words" {
+		return nil, fmt.Errorf("PATH_STYLE must be random or words")
+	}
+
+	for i, ext := range cfg.TextExtensions {
+		if !strings.HasPrefix(ext, ".") {
+			cfg.TextExtensions[i] = "." + ext
+		}
+	}
+
+	if cfg.FilenamePrecedence != "url" && cfg.FilenamePrecedence != "header" {
+		return nil, fmt.Errorf("FILENAME_PRECEDENCE must be url or header")
+	}
+
+	switch cfg.DownloadCountMode {
+	case "request", "complete", "all":
+	default:
+		return nil, fmt.Errorf("DOWNLOAD_COUNT_MODE must be request, complete or all")
+	}
+
+	switch cfg.UploadChallenge {
+	case "none", "pow":
+	case "captcha":
+		if cfg.CaptchaVerifyURL == "" || cfg.CaptchaSecret == "" {
+			return nil, fmt.Errorf("UPLOAD_CHALLENGE=captcha requires CAPTCHA_VERIFY_URL and CAPTCHA_SECRET")
+		}
+	default:
+		return nil, fmt.Errorf("UPLOAD_CHALLENGE must be none, pow or captcha")
+	}
+
+	var err error
+	if cfg.PowDifficulty, err = envInt("POW_DIFFICULTY", 18); err != nil {
+		return nil, err
+	}
+	if cfg.PowDifficulty < 1 || cfg.PowDifficulty > 32 {
+		return nil, fmt.Errorf("POW_DIFFICULTY must be between 1 and 32")
+	}
+	if cfg.CaseInsensitiveDownload, err = envBool("CASE_INSENSITIVE_DOWNLOAD", false); err != nil {
+		return nil, err
+	}
+	if cfg.DeleteConfirmation, err = envBool("DELETE_CONFIRMATION", false); err != nil {
+		return nil, err
+	}
+	if cfg.IdempotentDelete, err = envBool("IDEMPOTENT_DELETE", true); err != nil {
+		return nil, err
+	}
+	if cfg.DeleteCodeMinLength, err = envInt("DELETE_CODE_MIN_LENGTH", 8); err != nil {
+		return nil, err
+	}
+	if cfg.DeleteCodeMinClasses, err = envInt("DELETE_CODE_MIN_CLASSES", 2); err != nil {
+		return nil, err
+	}
+	if cfg.StorageQuotaBytes, err = envInt64("STORAGE_QUOTA_BYTES", 0); err != nil {
+		return nil, err
+	}
+	if cfg.WriteTimeout, err = envDuration("WRITE_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.WriteTimeout < 0 {
+		return nil, fmt.Errorf("WRITE_TIMEOUT must not be negative")
+	}
+	if cfg.OutboundTimeout, err = envDuration("OUTBOUND_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.OutboundConcurrency, err = envInt("OUTBOUND_CONCURRENCY", 8); err != nil {
+		return nil, err
+	}
+	if cfg.OutboundTimeout <= 0 || cfg.OutboundConcurrency <= 0 {
+		return nil, fmt.Errorf("OUTBOUND_TIMEOUT and OUTBOUND_CONCURRENCY must be positive")
+	}
+	if cfg.RefererAllowEmpty, err = envBool("REFERER_ALLOW_EMPTY", true); err != nil {
+		return nil, err
+	}
+	if cfg.NormalizeURLs, err = envBool("NORMALIZE_URLS", true); err != nil {
+		return nil, err
+	}
+	if cfg.PathReuse, err = envBool("PATH_REUSE", false); err != nil {
+		return nil, err
+	}
+	if cfg.DefaultExpiry, err = envExpiry("DEFAULT_EXPIRY", 3*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.MaxExpiry, err = envExpiry("MAX_EXPIRY", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.DefaultExpiry <= 0 || cfg.MaxExpiry <= 0 {
+		return nil, fmt.Errorf("DEFAULT_EXPIRY and MAX_EXPIRY must be positive")
+	}
+	if cfg.DefaultExpiry > cfg.MaxExpiry {
+		return nil, fmt.Errorf("DEFAULT_EXPIRY must not exceed MAX_EXPIRY")
+	}
+	if cfg.IdempotencyWindow, err = envDuration("IDEMPOTENCY_WINDOW", 0); err != nil {
+		return nil, err
+	}
+	if cfg.IdempotencyWindow < 0 {
+		return nil, fmt.Errorf("IDEMPOTENCY_WINDOW must not be negative")
+	}
+	if cfg.ShortLinks, err = envBool("SHORT_LINKS", false); err != nil {
+		return nil, err
+	}
+	if cfg.MetricsEnabled, err = envBool("METRICS_ENABLED", false); err != nil {
+		return nil, err
+	}
+	if cfg.FeedEnabled, err = envBool("FEED_ENABLED", false); err != nil {
+		return nil, err
+	}
+	if cfg.SlidingExpiry, err = envBool("SLIDING_EXPIRY", false); err != nil {
+		return nil, err
+	}
+	if cfg.CompressText, err = envBool("COMPRESS_TEXT", false); err != nil {
+		return nil, err
+	}
+	if cfg.RequireContentType, err = envBool("REQUIRE_CONTENT_TYPE", false); err != nil {
+		return nil, err
+	}
+	if cfg.RemoveEmptyOrphans, err = envBool("REMOVE_EMPTY_ORPHANS", true); err != nil {
+		return nil, err
+	}
+	if cfg.ConfineSymlinks, err = envBool("CONFINE_SYMLINKS", true); err != nil {
+		return nil, err
+	}
+	if cfg.CleanupBatchSize, err = envInt("CLEANUP_BATCH_SIZE", 500); err != nil {
+		return nil, err
+	}
+	if cfg.CleanupBatchSize <= 0 {
+		return nil, fmt.Errorf("CLEANUP_BATCH_SIZE must be positive")
+	}
+	if cfg.SignedURLSecret != "" && len(cfg.SignedURLSecret) < 32 {
+		return nil, fmt.Errorf("SIGNED_URL_SECRET must be at least 32 characters")
+	}
+	if cfg.DBWriteRetries, err = envInt("DB_WRITE_RETRIES", 3); err != nil {
+		return nil, err
+	}
+	if cfg.DBRetryBackoff, err = envDuration("DB_RETRY_BACKOFF", 50*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if cfg.DBWriteRetries < 0 || cfg.DBRetryBackoff <= 0 {
+		return nil, fmt.Errorf("DB_WRITE_RETRIES must not be negative and DB_RETRY_BACKOFF must be positive")
+	}
+	if cfg.Prefork, err = envBool("PREFORK", false); err != nil {
+		return nil, err
+	}
+	if cfg.DownloadPasswordMaxAttempts, err = envInt("DOWNLOAD_PASSWORD_MAX_ATTEMPTS", 5); err != nil {
+		return nil, err
+	}
+	if cfg.DownloadPasswordLockout, err = envDuration("DOWNLOAD_PASSWORD_LOCKOUT", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.DownloadPasswordMaxAttempts <= 0 || cfg.DownloadPasswordLockout <= 0 {
+		return nil, fmt.Errorf("DOWNLOAD_PASSWORD_MAX_ATTEMPTS and DOWNLOAD_PASSWORD_LOCKOUT must be positive")
+	}
+	if cfg.RequireUploadToken, err = envBool("REQUIRE_UPLOAD_TOKEN", false); err != nil {
+		return nil, err
+	}
+	if cfg.CleanupConcurrency, err = envInt("CLEANUP_CONCURRENCY", 1); err != nil {
+		return nil, err
+	}
+	if cfg.CleanupConcurrency <= 0 {
+		return nil, fmt.Errorf("CLEANUP_CONCURRENCY must be positive")
+	}
+	if cfg.CleanupBatchPause, err = envDuration("CLEANUP_BATCH_PAUSE", 100*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if cfg.IncompleteUploadTimeout, err = envDuration("INCOMPLETE_UPLOAD_TIMEOUT", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.IncompleteUploadTimeout <= 0 {
+		return nil, fmt.Errorf("INCOMPLETE_UPLOAD_TIMEOUT must be positive")
+	}
+	if cfg.VerifyPause, err = envDuration("VERIFY_PAUSE", 50*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if cfg.MaxDownloadsPerFile, err = envInt("MAX_DOWNLOADS_PER_FILE", 0); err != nil {
+		return nil, err
+	}
+	if cfg.MaxConnsPerIP, err = envInt("MAX_CONNS_PER_IP", 0); err != nil {
+		return nil, err
+	}
+	if cfg.MaxConnsPerIP < 0 {
+		return nil, fmt.Errorf("MAX_CONNS_PER_IP must not be negative")
+	}
+	if cfg.GlobalRateLimit, err = envInt("GLOBAL_RATE_LIMIT", 0); err != nil {
+		return nil, err
+	}
+	if cfg.GlobalRateBurst, err = envInt("GLOBAL_RATE_BURST", cfg.GlobalRateLimit); err != nil {
+		return nil, err
+	}
+	if cfg.GlobalRateLimit < 0 || (cfg.GlobalRateLimit > 0 && cfg.GlobalRateBurst < 1) {
+		return nil, fmt.Errorf("GLOBAL_RATE_LIMIT must not be negative and GLOBAL_RATE_BURST must be at least 1")
+	}
+	if cfg.NotFoundCacheTTL, err = envDuration("NOT_FOUND_CACHE_TTL", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.NotFoundCacheSize, err = envInt("NOT_FOUND_CACHE_SIZE", 10000); err != nil {
+		return nil, err
+	}
+	if cfg.NotFoundCacheTTL < 0 || cfg.NotFoundCacheSize < 0 {
+		return nil, fmt.Errorf("NOT_FOUND_CACHE_TTL and NOT_FOUND_CACHE_SIZE must not be negative")
+	}
+	if cfg.MinFreeBytes, err = envInt64("MIN_FREE_BYTES", 0); err != nil {
+		return nil, err
+	}
+	if cfg.MinFreePercent, err = envInt("MIN_FREE_PERCENT", 0); err != nil {
+		return nil, err
+	}
+	if cfg.MinFreePercent < 0 || cfg.MinFreePercent > 100 {
+		return nil, fmt.Errorf("MIN_FREE_PERCENT must be between 0 and 100")
+	}
+	if cfg.MaxFileSize, err = envInt64("MAX_FILE_SIZE", 1024*1024*1024); err != nil {
+		return nil, err
+	}
+	if cfg.MaxFilesPerRequest, err = envInt("MAX_FILES_PER_REQUEST", 20); err != nil {
+		return nil, err
+	}
+	if cfg.MaxFilesPerRequest <= 0 {
+		return nil, fmt.Errorf("MAX_FILES_PER_REQUEST must be positive")
+	}
+	if cfg.MaxDescriptionLength, err = envInt("MAX_DESCRIPTION_LENGTH", 500); err != nil {
+		return nil, err
+	}
+	if cfg.MaxDescriptionLength <= 0 {
+		return nil, fmt.Errorf("MAX_DESCRIPTION_LENGTH must be positive")
+	}
+	if cfg.VideoPosters, err = envBool("VIDEO_POSTERS", true); err != nil {
+		return nil, err
+	}
+	if cfg.PreviewMaxBytes, err = envInt64("PREVIEW_MAX_BYTES", 1024*1024); err != nil {
+		return nil, err
+	}
+	if cfg.PreviewMaxBytes <= 0 {
+		return nil, fmt.Errorf("PREVIEW_MAX_BYTES must be positive")
+	}
+	if cfg.LandingPageMaxAge, err = envDuration("LANDING_PAGE_MAX_AGE", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.LandingPageMaxAge < 0 {
+		return nil, fmt.Errorf("LANDING_PAGE_MAX_AGE must not be negative")
+	}
+	if cfg.RemoveDanglingRecords, err = envBool("REMOVE_DANGLING_RECORDS", false); err != nil {
+		return nil, err
+	}
+	if cfg.BlobMaxSize, err = envInt64("BLOB_MAX_SIZE", 0); err != nil {
+		return nil, err
+	}
+	if cfg.BlobMaxSize < 0 {
+		return nil, fmt.Errorf("BLOB_MAX_SIZE must not be negative")
+	}
+	if cfg.UploadSuccessStatus, err = envInt("UPLOAD_SUCCESS_STATUS", 201); err != nil {
+		return nil, err
+	}
+	if cfg.UploadSuccessStatus != 200 && cfg.UploadSuccessStatus != 201 {
+		return nil, fmt.Errorf("UPLOAD_SUCCESS_STATUS must be 200 or 201")
+	}
+	if cfg.DeleteCodeMinClasses < 0 || cfg.DeleteCodeMinClasses > 4 {
+		return nil, fmt.Errorf("DELETE_CODE_MIN_CLASSES must be between 0 and 4")
+	}
+
+	for _, alg := range cfg.ChecksumAlgorithms {
+		if _, ok := checksumAlgorithms[alg]; !ok {
+			return nil, fmt.Errorf("unsupported checksum algorithm: %s", alg)
+		}
+	}
+
+	return cfg, nil
+}
+
+func envString(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+func envBool(key string, def bool) (bool, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return b, nil
+}
+
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return d, nil
+}
+
+// envExpiry 与 envDuration 相同，但额外支持以天为单位，见 parseExpiry
+func envExpiry(key string, def time.Duration) (time.Duration, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, nil
+	}
+	d, err := parseExpiry(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return d, nil
+}
+
+// parseExpiry 解析保留时长，在 time.ParseDuration 的基础上支持以整数天开头，
+// 如 "30m"、"2h"、"7d"、"1d12h"
+func parseExpiry(v string) (time.Duration, error) {
+	var days time.Duration
+	if i := strings.IndexByte(v, 'd'); i > 0 {
+		n, err := strconv.Atoi(v[:i])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", v)
+		}
+		days, v = time.Duration(n)*24*time.Hour, v[i+1:]
+		if v == "" {
+			return days, nil
+		}
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	return days + d, nil
+}
+
+func envInt(key string, def int) (int, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return n, nil
+}
+
+func envInt64(key string, def int64) (int64, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return n, nil
+}
+
+// envList 解析逗号分隔的列表，统一转为小写
+func envList(key string, def []string) []string {
+	list := envListKeepCase(key, def)
+	if strings.TrimSpace(os.Getenv(key)) != "" {
+		for i, item := range list {
+			list[i] = strings.ToLower(item)
+		}
+	}
+	return list
+}
+
+// envListKeepCase 解析逗号分隔的列表并保留大小写，用于区分大小写的名称，如 multipart 表单字段名
+func envListKeepCase(key string, def []string) []string {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
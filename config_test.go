@@ -0,0 +1,53 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEnvList(t *testing.T) {
+	tests := []struct {
+		value    string
+		lower    []string
+		keepCase []string
+	}{
+		{"", []string{"default"}, []string{"default"}},
+		{"  ", []string{"default"}, []string{"default"}},
+		{"File", []string{"file"}, []string{"File"}},
+		{" File , upload,, MyFile ", []string{"file", "upload", "myfile"}, []string{"File", "upload", "MyFile"}},
+		{"*", []string{"*"}, []string{"*"}},
+	}
+	for _, tt := range tests {
+		t.Setenv("TINYUPLOAD_TEST_LIST", tt.value)
+		if got := envList("TINYUPLOAD_TEST_LIST", []string{"default"}); !slices.Equal(got, tt.lower) {
+			t.Errorf("envList(%q) = %q, want %q", tt.value, got, tt.lower)
+		}
+		if got := envListKeepCase("TINYUPLOAD_TEST_LIST", []string{"default"}); !slices.Equal(got, tt.keepCase) {
+			t.Errorf("envListKeepCase(%q) = %q, want %q", tt.value, got, tt.keepCase)
+		}
+	}
+}
+
+func TestUploadFieldNamesKeepCase(t *testing.T) {
+	// multipart 表单字段名区分大小写，配置的 File 必须原样保留才能匹配
+	t.Setenv("UPLOAD_FIELD_NAMES", "File,attachment")
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"File", "attachment"}; !slices.Equal(config.UploadFieldNames, want) {
+		t.Errorf("UploadFieldNames = %q, want %q", config.UploadFieldNames, want)
+	}
+}
+
+func TestMaxConnsPerIPDefault(t *testing.T) {
+	// 默认配置信任反向代理，按连接来源计数时所有客户端共用代理的地址，默认不能限制
+	t.Setenv("MAX_CONNS_PER_IP", "")
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MaxConnsPerIP != 0 {
+		t.Errorf("MaxConnsPerIP = %d by default, want 0", config.MaxConnsPerIP)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 删除确认令牌的有效期
+const confirmTokenTTL = 5 * time.Minute
+
+// confirmToken 生成与文件和删除码绑定的确认令牌，格式为 "<过期时间戳>.<HMAC>"
+func (s *FileServer) confirmToken(path, encodedFilename, deleteCode string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", path, encodedFilename, deleteCode, exp)
+	return exp + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *FileServer) verifyConfirmToken(token, path, encodedFilename, deleteCode string) bool {
+	exp, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return false
+	}
+	expires := time.Unix(unix, 0)
+	if time.Now().After(expires) {
+		return false
+	}
+	expected := s.confirmToken(path, encodedFilename, deleteCode, expires)
+	return hmac.Equal([]byte(token), []byte(expected))
+}
+
+// handleDeleteConfirm 删除前的第一步：校验删除码，返回文件信息和确认令牌
+func (s *FileServer) handleDeleteConfirm(c *fiber.Ctx) error {
+	path := c.Params("path")
+	decodedFilename, ok := decodeRequestFilename(c.Params("filename"))
+	if !ok {
+		return c.Status(404).SendString("File not found")
+	}
+	encodedFilename := url.QueryEscape(decodedFilename)
+
+	deleteCode, err := deleteCodeFromRequest(c)
+	if err != nil || deleteCode == "" {
+		return c.Status(401).SendString("Delete code required")
+	}
+	// 附加删除令牌换成原删除码，确认令牌与之绑定，DELETE 时同样先换成原删除码再校验
+	if deleteCode, _, err = s.resolveDeleteCode(path, encodedFilename, deleteCode); err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	var filename, mimeType string
+	var fileSize int64
+	var uploadTime time.Time
+	err = s.db.QueryRow(`
+       SELECT filename, file_size, COALESCE(mime_type, ''), upload_time
+       FROM files WHERE path = ? AND encoded_filename = ? AND delete_code = ?
+   `, path, encodedFilename, deleteCode).Scan(&filename, &fileSize, &mimeType, &uploadTime)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(403).SendString("Invalid delete code")
+		}
+		return dbUnavailable(c, err)
+	}
+
+	c.Set("Cache-Control", "no-store")
+	return sendJSON(c, fiber.Map{
+		"path":         path,
+		"filename":     filename,
+		"size":         fileSize,
+		"mimeType":     mimeType,
+		"uploadTime":   uploadTime.Local().Format(timeLayout),
+		"confirmToken": s.confirmToken(path, encodedFilename, deleteCode, time.Now().Add(confirmTokenTTL)),
+		"expiresIn":    int(confirmTokenTTL.Seconds()),
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// deleteTokensTable 管理员为单个文件签发的附加删除令牌，可交给协管员删除文件而无需透露原删除码；
+// 与上传令牌一样只保存 SHA-256，吊销后保留记录
+const deleteTokensTable = `
+       CREATE TABLE IF NOT EXISTS delete_tokens (
+           id INTEGER PRIMARY KEY AUTOINCREMENT,
+           file_id INTEGER NOT NULL,
+           token_hash TEXT NOT NULL UNIQUE,
+           label TEXT NOT NULL DEFAULT '',
+           created_at DATETIME NOT NULL,
+           revoked_at DATETIME
+       )
+   `
+
+// deleteTokenInfo 授权本次删除的附加删除令牌
+type deleteTokenInfo struct {
+	id    int64
+	label string
+}
+
+// resolveDeleteCode 客户端提供的是该文件未吊销的附加删除令牌时，返回原删除码与令牌信息；
+// 否则原样返回 code，由后续的删除码校验决定是否有效
+func (s *FileServer) resolveDeleteCode(path, encodedFilename, code string) (string, *deleteTokenInfo, error) {
+	if code == "" {
+		return code, nil, nil
+	}
+	var deleteCode string
+	t := &deleteTokenInfo{}
+	err := s.db.QueryRow(`
+       SELECT f.delete_code, t.id, t.label FROM delete_tokens t JOIN files f ON f.id = t.file_id
+       WHERE t.token_hash = ? AND t.revoked_at IS NULL AND f.path = ? AND f.encoded_filename = ?
+   `, hashUploadToken(code), path, encodedFilename).Scan(&deleteCode, &t.id, &t.label)
+	if err == sql.ErrNoRows {
+		return code, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return deleteCode, t, nil
+}
+
+// handleCreateDeleteToken 为文件签发附加删除令牌，请求体可选 {"label": "..."} 标识令牌的持有者；
+// 令牌原文只在创建时返回一次
+func (s *FileServer) handleCreateDeleteToken(c *fiber.Ctx) error {
+	path := c.Params("path")
+	decodedFilename, ok := decodeRequestFilename(c.Params("filename"))
+	if !ok {
+		return c.Status(404).SendString("File not found")
+	}
+	var req struct {
+		Label string `json:"label"`
+	}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).SendString("Invalid JSON body")
+		}
+	}
+	label := strings.TrimSpace(req.Label)
+
+	var fileID int64
+	err := s.db.QueryRow("SELECT id FROM files WHERE path = ? AND encoded_filename = ?",
+		path, url.QueryEscape(decodedFilename)).Scan(&fileID)
+	if err == sql.ErrNoRows {
+		return c.Status(404).SendString("File not found")
+	}
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	token := generateRandomString(uploadTokenLength)
+	result, err := s.db.Exec(`
+       INSERT INTO delete_tokens (file_id, token_hash, label, created_at) VALUES (?, ?, ?, datetime('now'))
+   `, fileID, hashUploadToken(token), label)
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	log.Printf("Issued delete token %d (%q) for %s/%s", id, label, path, decodedFilename)
+
+	c.Set("Cache-Control", "no-store")
+	return sendJSON(c.Status(201), fiber.Map{
+		"id":        id,
+		"label":     label,
+		"token":     token,
+		"path":      path,
+		"filename":  decodedFilename,
+		"createdAt": time.Now().Format(timeLayout),
+	})
+}
+
+// handleListDeleteTokens 列出文件的附加删除令牌 (不含令牌原文)，包括已吊销的
+func (s *FileServer) handleListDeleteTokens(c *fiber.Ctx) error {
+	path := c.Params("path")
+	decodedFilename, ok := decodeRequestFilename(c.Params("filename"))
+	if !ok {
+		return c.Status(404).SendString("File not found")
+	}
+
+	rows, err := s.db.Query(`
+       SELECT t.id, t.label, t.created_at, t.revoked_at FROM delete_tokens t JOIN files f ON f.id = t.file_id
+       WHERE f.path = ? AND f.encoded_filename = ? ORDER BY t.id
+   `, path, url.QueryEscape(decodedFilename))
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	defer rows.Close()
+
+	tokens := []fiber.Map{}
+	for rows.Next() {
+		var id int64
+		var label string
+		var createdAt time.Time
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&id, &label, &createdAt, &revokedAt); err != nil {
+			return dbUnavailable(c, err)
+		}
+		token := fiber.Map{
+			"id":        id,
+			"label":     label,
+			"createdAt": createdAt.Local().Format(timeLayout),
+			"revoked":   revokedAt.Valid,
+			"revokedAt": nil,
+		}
+		if revokedAt.Valid {
+			token["revokedAt"] = revokedAt.Time.Local().Format(timeLayout)
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	return sendJSON(c, tokens)
+}
+
+// handleRevokeDeleteToken 吊销附加删除令牌，之后凭该令牌删除返回 403；重复吊销不报错
+func (s *FileServer) handleRevokeDeleteToken(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(404).SendString("Token not found")
+	}
+
+	result, err := s.db.Exec("UPDATE delete_tokens SET revoked_at = COALESCE(revoked_at, datetime('now')) WHERE id = ?", id)
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return c.Status(404).SendString("Token not found")
+	}
+	log.Printf("Revoked delete token %d", id)
+	return sendJSON(c, fiber.Map{"id": id, "revoked": true})
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// diskSpace 在不支持 statfs 的平台上不可用，磁盘余量检查将被跳过
+func diskSpace(dir string) (free, total uint64, err error) {
+	return 0, 0, errors.New("disk space check not supported on this platform")
+}
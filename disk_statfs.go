@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package main
+
+import "syscall"
+
+// diskSpace 返回目录所在文件系统对普通用户可用的字节数和总字节数
+func diskSpace(dir string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
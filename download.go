@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// trackedReader 包装下载的文件内容，在响应发送完毕或连接中断后回调实际发送的字节数
+type trackedReader struct {
+	r       io.Reader
+	closer  io.Closer
+	sent    int64
+	once    sync.Once
+	onClose func(sent int64)
+}
+
+func (t *trackedReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.sent += int64(n)
+	return n, err
+}
+
+func (t *trackedReader) Close() error {
+	var err error
+	if t.closer != nil {
+		err = t.closer.Close()
+	}
+	t.once.Do(func() {
+		if t.onClose != nil {
+			t.onClose(t.sent)
+		}
+	})
+	return err
+}
+
+// errSizeMismatch 存储的字节数与记录的大小不一致 (存储发生了漂移)，此时不发送内容
+var errSizeMismatch = errors.New("stored size does not match the record")
+
+// checkStoredSize 核对存储的字节数与记录的大小，recorded 为 0 表示没有可核对的记录
+func checkStoredSize(recorded, stored int64) error {
+	if recorded > 0 && stored != recorded {
+		return fmt.Errorf("%w: recorded %d bytes, stored %d bytes", errSizeMismatch, recorded, stored)
+	}
+	return nil
+}
+
+// serveFile 以流的方式发送文件，支持单段 Range 请求；Content-Length 按记录的大小 size 给出，
+// 磁盘上的字节数与之不一致时返回 errSizeMismatch。
+// fasthttp 在处理函数返回后才真正发送数据，onDone 在发送结束时调用，
+// 提前返回 (包括出错) 时也保证恰好调用一次
+func serveFile(c *fiber.Ctx, filePath string, size int64, mimeType string, onDone func(sent int64)) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		onDone(0)
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		err = checkStoredSize(size, info.Size())
+	}
+	if err != nil {
+		f.Close()
+		onDone(0)
+		return err
+	}
+	return serveContent(c, f, f, info.Size(), info.ModTime(), filePath, mimeType, onDone)
+}
+
+// serveBlob 发送存储在数据库中的文件内容，修改时间取上传时间
+func serveBlob(c *fiber.Ctx, content []byte, filename, mimeType string, modTime time.Time, onDone func(sent int64)) error {
+	return serveContent(c, bytes.NewReader(content), nil, int64(len(content)), modTime, filename, mimeType, onDone)
+}
+
+// 标记不经过全局压缩的响应的 Locals 键
+const skipCompressionKey = "skipCompression"
+
+// compressResponses 与 compress 中间件一样按 Accept-Encoding 压缩响应 (最快级别)，
+// 但跳过 serveContent 发送的文件内容：压缩流式响应会去掉 Content-Length，
+// Range 响应的 Content-Range 也不再与压缩后的内容对应
+func compressResponses() fiber.Handler {
+	compressor := fasthttp.CompressHandlerBrotliLevel(func(*fasthttp.RequestCtx) {},
+		fasthttp.CompressBrotliBestSpeed, fasthttp.CompressBestSpeed)
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return err
+		}
+		if c.Locals(skipCompressionKey) == nil {
+			compressor(c.Context())
+		}
+		return nil
+	}
+}
+
+// serveContent 设置响应头并处理 Range，closer 在发送结束或出错时关闭；
+// 内容按原样发送，不经过全局压缩
+func serveContent(c *fiber.Ctx, content io.ReadSeeker, closer io.Closer, size int64, modTime time.Time,
+	name, mimeType string, onDone func(sent int64)) error {
+	abort := func() {
+		if closer != nil {
+			closer.Close()
+		}
+		onDone(0)
+	}
+
+	c.Locals(skipCompressionKey, true)
+	c.Set(fiber.HeaderContentType, responseContentType(name, mimeType))
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	c.Set(fiber.HeaderLastModified, modTime.UTC().Format(http.TimeFormat))
+
+	start, end := int64(0), size-1
+	status := 200
+	if rangeHeader := c.Get(fiber.HeaderRange); rangeHeader != "" && size > 0 {
+		rangeStart, rangeEnd, err := fasthttp.ParseByteRange([]byte(rangeHeader), int(size))
+		if err != nil {
+			abort()
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", size))
+			return c.SendStatus(416)
+		}
+		start, end = int64(rangeStart), int64(rangeEnd)
+		status = 206
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+
+	if _, err := content.Seek(start, io.SeekStart); err != nil {
+		abort()
+		return err
+	}
+	length := end - start + 1
+	// 发送不完整通常是客户端断开或超过 WRITE_TIMEOUT，记录下来便于调整超时
+	head := c.Method() == fiber.MethodHead
+	var body io.Reader = io.LimitReader(content, length)
+	bodySize := int(length)
+	// 尾部字段只能随分块传输发送，此时不再给出 Content-Length
+	if !head && checksumTrailerRequested(c) {
+		body = newTrailerChecksumReader(body, &c.Response().Header)
+		bodySize = -1
+	}
+	c.Status(status)
+	c.Response().SetBodyStream(&trackedReader{
+		r:      body,
+		closer: closer,
+		onClose: func(sent int64) {
+			if !head && sent < length {
+				log.Printf("Transfer of %s interrupted after %d of %d bytes", filepath.Base(name), sent, length)
+			}
+			onDone(sent)
+		},
+	}, bodySize)
+	return nil
+}
+
+// responseContentType 下载响应的 Content-Type：优先按扩展名，其次为记录的类型
+func responseContentType(name, mimeType string) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		return contentType
+	}
+	if mimeType != "" {
+		return mimeType
+	}
+	return fiber.MIMEOctetStream
+}
+
+// activeContentTypes 在浏览器中直接打开时可以执行脚本的类型，始终作为附件下载
+var activeContentTypes = []string{
+	"text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml",
+	"text/javascript", "application/javascript",
+}
+
+// contentDisposition 按 INLINE_TYPES 决定文件在浏览器中直接打开还是作为附件下载，
+// ?inline=1 或 ?inline=0 可覆盖默认值，但可执行脚本的类型始终作为附件
+func (s *FileServer) contentDisposition(c *fiber.Ctx, contentType, filename string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	inline := false
+	for _, prefix := range s.config.InlineTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			inline = true
+			break
+		}
+	}
+	if override, err := strconv.ParseBool(c.Query("inline")); err == nil {
+		inline = override
+	}
+	for _, active := range activeContentTypes {
+		if mediaType == active {
+			inline = false
+		}
+	}
+
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+	}
+	// 非 ASCII 文件名按 RFC 2231 编码为 filename*
+	if formatted := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); formatted != "" {
+		return formatted
+	}
+	return disposition
+}
+
+// isRangeProbe 判断是否为探测性的 Range 请求，例如浏览器和链接预览常用的 bytes=0-0
+func isRangeProbe(rangeHeader string, size int64) bool {
+	if rangeHeader == "" {
+		return false
+	}
+	if size <= 0 {
+		return true
+	}
+	start, end, err := fasthttp.ParseByteRange([]byte(rangeHeader), int(size))
+	return err != nil || end-start+1 <= 1
+}
+
+// countsAsDownload 按 DOWNLOAD_COUNT_MODE 判断一次下载是否计入下载次数，HEAD 请求始终不计
+func countsAsDownload(mode string, head, probe bool, sent, size int64) bool {
+	if head {
+		return false
+	}
+	switch mode {
+	case "all":
+		return true
+	case "complete":
+		return sent >= size
+	default:
+		return !probe && sent > 0
+	}
+}
+
+// incrementDownloadCount 在下载发送结束后累加下载次数，
+// 开启 SLIDING_EXPIRY 时同时把过期时间顺延为当前时间加该文件上传时的保留时长 (不会提前)。
+// 保留时长单独保存，不能由顺延过的过期时间推算，否则每次顺延都会越来越长；没有保留时长的记录不顺延
+func (s *FileServer) incrementDownloadCount(path, encodedFilename string) {
+	var err error
+	if s.config.SlidingExpiry {
+		_, err = s.execWithRetry(`
+           UPDATE files SET download_count = download_count + 1,
+                            expires_at = CASE WHEN retention_seconds IS NULL THEN expires_at
+                                              ELSE MAX(expires_at, datetime('now', '+' || retention_seconds || ' seconds')) END
+           WHERE path = ? AND encoded_filename = ?
+       `, path, encodedFilename)
+	} else {
+		_, err = s.execWithRetry("UPDATE files SET download_count = download_count + 1 WHERE path = ? AND encoded_filename = ?",
+			path, encodedFilename)
+	}
+	if err != nil {
+		log.Printf("Error updating download count: %v", err)
+	}
+}
+
+// downloadLimiter 限制同一文件同时进行的下载数，防止单个热门链接占满磁盘 I/O
+type downloadLimiter struct {
+	mu     sync.Mutex
+	active map[string]int
+}
+
+func newDownloadLimiter() *downloadLimiter {
+	return &downloadLimiter{active: make(map[string]int)}
+}
+
+// Acquire 占用一个下载名额，max <= 0 表示不限制
+func (l *downloadLimiter) Acquire(key string, max int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if max > 0 && l.active[key] >= max {
+		return false
+	}
+	l.active[key]++
+	return true
+}
+
+func (l *downloadLimiter) Release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[key]--; l.active[key] <= 0 {
+		delete(l.active, key)
+	}
+}
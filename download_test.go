@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestIsRangeProbe(t *testing.T) {
+	tests := []struct {
+		header string
+		size   int64
+		want   bool
+	}{
+		{"", 1000, false},
+		{"bytes=0-0", 1000, true},
+		{"bytes=999-999", 1000, true},
+		{"bytes=-1", 1000, true},
+		{"bytes=0-1", 1000, false},
+		{"bytes=0-", 1000, false},
+		{"bytes=500-999", 1000, false},
+		{"bytes=-500", 1000, false},
+		// 无法解析或超出文件范围的 Range 同样视为探测
+		{"bytes=abc", 1000, true},
+		{"bytes=2000-3000", 1000, true},
+		{"bytes=0-0", 0, true},
+	}
+	for _, tt := range tests {
+		if got := isRangeProbe(tt.header, tt.size); got != tt.want {
+			t.Errorf("isRangeProbe(%q, %d) = %v, want %v", tt.header, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestCountsAsDownload(t *testing.T) {
+	const size = 1000
+	tests := []struct {
+		name  string
+		mode  string
+		head  bool
+		probe bool
+		sent  int64
+		want  bool
+	}{
+		{"request: complete read", "request", false, false, size, true},
+		{"request: partial read", "request", false, false, 400, true},
+		{"request: probe", "request", false, true, 1, false},
+		{"request: nothing sent", "request", false, false, 0, false},
+		{"request: HEAD", "request", true, false, 0, false},
+
+		{"complete: complete read", "complete", false, false, size, true},
+		{"complete: partial read", "complete", false, false, 400, false},
+		{"complete: probe", "complete", false, true, 1, false},
+		{"complete: HEAD", "complete", true, false, size, false},
+
+		{"all: complete read", "all", false, false, size, true},
+		{"all: partial read", "all", false, false, 400, true},
+		{"all: probe", "all", false, true, 1, true},
+		{"all: nothing sent", "all", false, false, 0, true},
+		{"all: HEAD", "all", true, false, 0, false},
+	}
+	for _, tt := range tests {
+		if got := countsAsDownload(tt.mode, tt.head, tt.probe, tt.sent, size); got != tt.want {
+			t.Errorf("%s: countsAsDownload = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckStoredSize(t *testing.T) {
+	tests := []struct {
+		recorded, stored int64
+		mismatch         bool
+	}{
+		{1000, 1000, false},
+		{1000, 1005, true},
+		{1000, 0, true},
+		// 没有可核对的大小 (如签名链接中以 gzip 存储的文件) 时不核对
+		{0, 1000, false},
+	}
+	for _, tt := range tests {
+		err := checkStoredSize(tt.recorded, tt.stored)
+		if got := errors.Is(err, errSizeMismatch); got != tt.mismatch {
+			t.Errorf("checkStoredSize(%d, %d) = %v, want mismatch %v", tt.recorded, tt.stored, err, tt.mismatch)
+		}
+	}
+}
+
+func newDownloadTestServer(t *testing.T) *FileServer {
+	t.Helper()
+	s := &FileServer{
+		db:        newTestDB(t),
+		uploadDir: t.TempDir(),
+		config:    &Config{SlidingExpiry: true, PreviewMaxBytes: 1024, DownloadCountMode: "request"},
+		downloads: newDownloadLimiter(),
+		readers:   newFileReaders(),
+		pathLocks: newKeyedMutex(),
+		app:       fiber.New(),
+	}
+	s.app.Get("/preview/:path/:filename", s.handlePreview)
+	s.app.Get("/thumbnail/:path/:filename", s.handleThumbnail)
+	s.app.Get("/:path/:filename", s.handleDownload)
+	return s
+}
+
+// addDownloadTestFile 写入 path/file.txt 及其记录，expiresIn 为负时记录已过期但尚未被清理
+func addDownloadTestFile(t *testing.T, s *FileServer, path string, expiresIn time.Duration) {
+	t.Helper()
+	filePath := filepath.Join(s.uploadDir, path, "file.txt")
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.db.Exec(`
+       INSERT INTO files (path, filename, encoded_filename, delete_code, upload_time, file_size, mime_type,
+                          storage_dir, expires_at, retention_seconds)
+       VALUES (?, 'file.txt', 'file.txt', 'code', datetime('now', '-1 day'), 7, 'text/plain', ?, ?, 3600)
+   `, path, path, time.Now().UTC().Add(expiresIn).Format(timeLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpiredFileNotServed(t *testing.T) {
+	s := newDownloadTestServer(t)
+	addDownloadTestFile(t, s, "live", time.Hour)
+	addDownloadTestFile(t, s, "expired", -time.Minute)
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/live/file.txt", 200},
+		{"/preview/live/file.txt", 200},
+		{"/expired/file.txt", 410},
+		{"/expired/file.txt?raw=1", 410},
+		{"/preview/expired/file.txt", 410},
+		{"/thumbnail/expired/file.txt", 410},
+	}
+	for _, tt := range tests {
+		resp, err := s.app.Test(httptest.NewRequest("GET", tt.url, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.url, resp.StatusCode, tt.status)
+		}
+	}
+
+	// 请求已过期的文件不计入下载，SLIDING_EXPIRY 也不会顺延其过期时间
+	var count int
+	var expired bool
+	err := s.db.QueryRow("SELECT download_count, expires_at < datetime('now') FROM files WHERE path = 'expired'").
+		Scan(&count, &expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 || !expired {
+		t.Errorf("expired file: download_count = %d, still expired = %v; want 0, true", count, expired)
+	}
+}
+
+// TestDownloadNotRecompressed 客户端支持 gzip 时，下载仍按记录给出 Content-Length，
+// Range 响应的 Content-Range 与内容一致；其他响应照常压缩
+func TestDownloadNotRecompressed(t *testing.T) {
+	s := newTestServer(t, nil)
+	content := []byte(strings.Repeat(`{"key": "value"}`+"\n", 500))
+	gzipHeaders := map[string]string{"Accept-Encoding": "gzip"}
+
+	status, body := putUpload(t, s, "data.json", content, gzipHeaders)
+	if status != 201 {
+		t.Fatalf("upload = %d", status)
+	}
+	// 上传结果 (JSON) 仍经过压缩
+	var result map[string]interface{}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("upload response not compressed: %v", err)
+	}
+	if err := json.NewDecoder(zr).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	url := fmt.Sprintf("/%s/data.json?raw=1", result["path"])
+
+	tests := []struct {
+		rangeHeader  string
+		status       int
+		contentRange string
+		body         []byte
+	}{
+		{"", 200, "", content},
+		{"bytes=100-199", 206, fmt.Sprintf("bytes 100-199/%d", len(content)), content[100:200]},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		if tt.rangeHeader != "" {
+			req.Header.Set("Range", tt.rangeHeader)
+		}
+		resp, err := s.app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("Range %q: status %d, want %d", tt.rangeHeader, resp.StatusCode, tt.status)
+		}
+		if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+			t.Errorf("Range %q: Content-Encoding %q", tt.rangeHeader, enc)
+		}
+		if resp.ContentLength != int64(len(tt.body)) {
+			t.Errorf("Range %q: Content-Length %d, want %d", tt.rangeHeader, resp.ContentLength, len(tt.body))
+		}
+		if cr := resp.Header.Get("Content-Range"); cr != tt.contentRange {
+			t.Errorf("Range %q: Content-Range %q, want %q", tt.rangeHeader, cr, tt.contentRange)
+		}
+		if !bytes.Equal(got, tt.body) {
+			t.Errorf("Range %q: body differs from the stored content", tt.rangeHeader)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// fileRecord 导出/导入时每行 JSON 对应的文件元数据
+type fileRecord struct {
+	Path            string     `json:"path"`
+	Filename        string     `json:"filename"`
+	EncodedFilename string     `json:"encodedFilename"`
+	DeleteCode      string     `json:"deleteCode"`
+	UploadTime      time.Time  `json:"uploadTime"`
+	ExpiresAt       *time.Time `json:"expiresAt"`
+	FileSize        int64      `json:"fileSize"`
+	MimeType        string     `json:"mimeType,omitempty"`
+	DownloadCount   int64      `json:"downloadCount"`
+	ChecksumMD5     string     `json:"checksumMd5,omitempty"`
+	ChecksumSHA1    string     `json:"checksumSha1,omitempty"`
+	ChecksumSHA256  string     `json:"checksumSha256,omitempty"`
+	StorageDir      string     `json:"storageDir,omitempty"`
+	// 存储在数据库中的文件内容，JSON 中为 base64
+	Content []byte `json:"content,omitempty"`
+	// 以 gzip 压缩存储时为 true，StoredSize 为实际存储的字节数
+	Compressed bool  `json:"compressed,omitempty"`
+	StoredSize int64 `json:"storedSize,omitempty"`
+	// 上传者 IP，用于滥用排查
+	UploaderIP  string `json:"uploaderIp,omitempty"`
+	Description string `json:"description,omitempty"`
+	// 私有文件下载时需提供访问码或删除码
+	Private    bool   `json:"private,omitempty"`
+	AccessCode string `json:"accessCode,omitempty"`
+	// 下载密码的哈希，导入后原密码仍然有效
+	PasswordHash string `json:"passwordHash,omitempty"`
+	// 上传时的保留时长 (秒)，SLIDING_EXPIRY 按此顺延过期时间
+	RetentionSeconds int64 `json:"retentionSeconds,omitempty"`
+}
+
+// handleExport 以 JSON Lines 格式流式导出全部文件元数据，不在内存中汇总
+func (s *FileServer) handleExport(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="files.jsonl"`)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		rows, err := s.db.Query(`
+           SELECT path, filename, encoded_filename, delete_code, upload_time, expires_at, file_size,
+                  COALESCE(mime_type, ''), download_count,
+                  COALESCE(checksum_md5, ''), COALESCE(checksum_sha1, ''), COALESCE(checksum_sha256, ''),
+                  COALESCE(storage_dir, ''), content, compressed, COALESCE(stored_size, file_size),
+                  COALESCE(uploader_ip, ''), COALESCE(description, ''),
+                  private, COALESCE(access_code, ''), COALESCE(password_hash, ''), COALESCE(retention_seconds, 0)
+           FROM files ORDER BY id
+       `)
+		if err != nil {
+			log.Printf("Export failed: %v", err)
+			return
+		}
+		defer rows.Close()
+
+		enc := json.NewEncoder(w)
+		for rows.Next() {
+			var r fileRecord
+			if err := rows.Scan(&r.Path, &r.Filename, &r.EncodedFilename, &r.DeleteCode, &r.UploadTime, &r.ExpiresAt,
+				&r.FileSize, &r.MimeType, &r.DownloadCount,
+				&r.ChecksumMD5, &r.ChecksumSHA1, &r.ChecksumSHA256, &r.StorageDir, &r.Content,
+				&r.Compressed, &r.StoredSize, &r.UploaderIP, &r.Description,
+				&r.Private, &r.AccessCode, &r.PasswordHash, &r.RetentionSeconds); err != nil {
+				log.Printf("Export failed: %v", err)
+				return
+			}
+			if err := enc.Encode(&r); err != nil {
+				log.Printf("Export aborted: %v", err)
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Export failed: %v", err)
+		}
+	})
+	return nil
+}
+
+// 导入时单行 JSON 的最大长度
+const maxImportLineSize = 1024 * 1024
+
+// handleImport 读取 JSON Lines 格式的元数据重建记录，已存在的记录跳过；
+// ?verify=1 时只导入磁盘上仍存在对应文件的记录
+func (s *FileServer) handleImport(c *fiber.Ctx) error {
+	verify, _ := strconv.ParseBool(c.Query("verify"))
+
+	var imported, skipped, missing int
+	errs := []string{}
+
+	scanner := bufio.NewScanner(requestBodyReader(c))
+	// 数据库存储的文件内容以 base64 内联在记录中，按其上限放宽单行长度
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineSize+int(s.config.BlobMaxSize)*4/3)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		// expiresAt 为 null 表示永久保留，与缺少该字段 (旧版本导出) 区分开
+		var rec struct {
+			fileRecord
+			ExpiresAt json.RawMessage `json:"expiresAt"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			errs = append(errs, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		r := rec.fileRecord
+		if rec.ExpiresAt != nil {
+			if err := json.Unmarshal(rec.ExpiresAt, &r.ExpiresAt); err != nil {
+				errs = append(errs, fmt.Sprintf("line %d: %v", line, err))
+				continue
+			}
+		}
+		if r.Path == "" || r.Filename == "" || sanitizeFilename(r.Filename) != r.Filename {
+			errs = append(errs, fmt.Sprintf("line %d: invalid path or filename", line))
+			continue
+		}
+		if err := validateStoragePath(r.Path, r.StorageDir); err != nil {
+			errs = append(errs, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		if r.EncodedFilename == "" {
+			r.EncodedFilename = url.QueryEscape(r.Filename)
+		}
+		if r.DeleteCode == "" {
+			r.DeleteCode = generateRandomString(s.generatedDeleteCodeLength())
+		}
+		if r.UploadTime.IsZero() {
+			r.UploadTime = time.Now()
+		}
+		if r.StoredSize == 0 {
+			r.StoredSize = r.FileSize
+		}
+		if rec.ExpiresAt == nil {
+			// 旧版本导出的记录没有过期时间，按上传时间加保留期计算
+			expiresAt := r.UploadTime.Add(s.config.DefaultExpiry)
+			r.ExpiresAt = &expiresAt
+		}
+		var expiresAt, retention interface{}
+		if r.ExpiresAt != nil {
+			expiresAt = r.ExpiresAt.UTC().Format(timeLayout)
+		}
+		if r.RetentionSeconds > 0 {
+			retention = r.RetentionSeconds
+		} else if r.ExpiresAt != nil && r.ExpiresAt.Sub(r.UploadTime) > 0 {
+			// 旧版本导出的记录没有保留时长，与升级时补齐旧记录一样按过期时间与上传时间之差计算
+			retention = int64(r.ExpiresAt.Sub(r.UploadTime).Seconds())
+		}
+
+		storageDir := r.StorageDir
+		if storageDir == "" {
+			storageDir = r.Path
+		}
+		if verify && r.Content == nil {
+			if _, err := os.Stat(filepath.Join(s.uploadDir, storageDir, r.Filename)); err != nil {
+				missing++
+				continue
+			}
+		}
+
+		var content interface{}
+		if len(r.Content) > 0 {
+			content = r.Content
+		}
+		result, err := s.db.Exec(`
+           INSERT OR IGNORE INTO files (path, filename, encoded_filename, delete_code, upload_time, expires_at,
+                                        file_size, mime_type, download_count, checksum_md5, checksum_sha1,
+                                        checksum_sha256, storage_dir, content, compressed, stored_size,
+                                        uploader_ip, description, private, access_code, password_hash,
+                                        retention_seconds)
+           VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+       `, r.Path, r.Filename, r.EncodedFilename, r.DeleteCode, r.UploadTime.UTC().Format(timeLayout),
+			expiresAt, r.FileSize,
+			nullIfEmpty(r.MimeType), r.DownloadCount,
+			nullIfEmpty(r.ChecksumMD5), nullIfEmpty(r.ChecksumSHA1), nullIfEmpty(r.ChecksumSHA256),
+			nullIfEmpty(r.StorageDir), content, r.Compressed, r.StoredSize,
+			nullIfEmpty(r.UploaderIP), nullIfEmpty(r.Description), r.Private, nullIfEmpty(r.AccessCode),
+			nullIfEmpty(r.PasswordHash), retention)
+		if err != nil {
+			return dbUnavailable(c, err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			skipped++
+			continue
+		}
+		s.quota.Commit(0, r.StoredSize)
+		s.notFound.Invalidate(r.Path)
+		imported++
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	return sendJSON(c, fiber.Map{
+		"imported": imported,
+		"skipped":  skipped,
+		"missing":  missing,
+		"errors":   errs,
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestImportExpiresAt 显式的 "expiresAt": null 是永久文件，只有缺少该字段时才按 DEFAULT_EXPIRY 补齐
+func TestImportExpiresAt(t *testing.T) {
+	s := newTestServer(t, map[string]string{"ADMIN_KEY": "admin", "DEFAULT_EXPIRY": "24h"})
+	body := strings.Join([]string{
+		`{"path":"perm","filename":"a.txt","uploadTime":"2024-01-01T00:00:00Z","expiresAt":null,"fileSize":1}`,
+		`{"path":"legacy","filename":"a.txt","uploadTime":"2024-01-01T00:00:00Z","fileSize":1}`,
+		`{"path":"dated","filename":"a.txt","uploadTime":"2024-01-01T00:00:00Z","expiresAt":"2024-01-03T00:00:00Z","fileSize":1}`,
+	}, "\n")
+	req := httptest.NewRequest("POST", "/admin/import", strings.NewReader(body))
+	req.Header.Set("X-Admin-Key", "admin")
+	resp, err := s.app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("POST /admin/import = %d", resp.StatusCode)
+	}
+
+	uploadTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		path      string
+		expiresAt *time.Time
+	}{
+		{"perm", nil},
+		{"legacy", timePtr(uploadTime.Add(24 * time.Hour))},
+		{"dated", timePtr(uploadTime.Add(48 * time.Hour))},
+	}
+	for _, tt := range tests {
+		var expiresAt sql.NullTime
+		if err := s.db.QueryRow("SELECT expires_at FROM files WHERE path = ?", tt.path).Scan(&expiresAt); err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		switch {
+		case tt.expiresAt == nil && expiresAt.Valid:
+			t.Errorf("%s: expires_at = %v, want NULL", tt.path, expiresAt.Time)
+		case tt.expiresAt != nil && (!expiresAt.Valid || !expiresAt.Time.Equal(*tt.expiresAt)):
+			t.Errorf("%s: expires_at = %v, want %v", tt.path, expiresAt, *tt.expiresAt)
+		}
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
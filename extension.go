@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// preferredExtensions 常见类型的惯用扩展名；mime.ExtensionsByType 按字母排序，
+// 如 image/jpeg 会先得到 .jfif，其他类型才取其中第一个
+var preferredExtensions = map[string]string{
+	"image/jpeg":       ".jpg",
+	"image/png":        ".png",
+	"image/gif":        ".gif",
+	"image/webp":       ".webp",
+	"image/svg+xml":    ".svg",
+	"application/pdf":  ".pdf",
+	"application/zip":  ".zip",
+	"application/gzip": ".gz",
+	"application/json": ".json",
+	"text/html":        ".html",
+	"text/csv":         ".csv",
+	"audio/mpeg":       ".mp3",
+	"audio/wav":        ".wav",
+	"video/mp4":        ".mp4",
+	"video/webm":       ".webm",
+	"video/quicktime":  ".mov",
+}
+
+// extensionForType 返回 MIME 类型对应的扩展名，未知类型返回空字符串
+func extensionForType(mediaType string) string {
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// correctExtension 文件名缺少扩展名或扩展名与声明/识别出的类型不符时，按 EXTENSION_CHECK
+// 记录警告 (warn) 或在文件名末尾追加正确的扩展名 (append)，下载者保存文件时能得到正确的类型。
+// 类型无法确定 (application/octet-stream)、无扩展名的纯文本 (如 LICENSE、Makefile)
+// 以及扩展名与类型同为文本类时不处理
+func (s *FileServer) correctExtension(filename, mimeType string) string {
+	if s.config.ExtensionCheck == "off" || s.isTextExtension(filename) {
+		return filename
+	}
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil || mediaType == fiber.MIMEOctetStream {
+		return filename
+	}
+	ext := filepath.Ext(filename)
+	if ext == "" && mediaType == fiber.MIMETextPlain {
+		return filename
+	}
+	extType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+	if extType == mediaType || (strings.HasPrefix(extType, "text/") && strings.HasPrefix(mediaType, "text/")) {
+		return filename
+	}
+	want := extensionForType(mediaType)
+	if want == "" {
+		return filename
+	}
+
+	if s.config.ExtensionCheck == "warn" {
+		log.Printf("Extension of %q does not match its type %s, expected %s", filename, mediaType, want)
+		return filename
+	}
+	// 追加后仍不超过 255 字节
+	if over := len(filename) + len(want) - 255; over > 0 {
+		filename = strings.ToValidUTF8(filename[:max(len(filename)-over, 0)], "")
+	}
+	log.Printf("Appending %s to %q to match its type %s", want, filename, mediaType)
+	return filename + want
+}
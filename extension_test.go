@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCorrectExtension(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		mimeType string
+		want     string
+	}{
+		{"matching extension", "photo.png", "image/png", "photo.png"},
+		{"matching extension, other case", "photo.JPG", "image/jpeg", "photo.JPG"},
+		{"matching extension with parameters", "page.html", "text/html; charset=utf-8", "page.html"},
+		{"alternative text extension", "notes.md", "text/plain; charset=utf-8", "notes.md"},
+		{"mismatched extension", "photo.txt", "image/png", "photo.txt.png"},
+		{"mismatched extension uses the usual one", "scan.png", "image/jpeg", "scan.png.jpg"},
+		{"no extension", "photo", "image/png", "photo.png"},
+		{"no extension, multi-dot name", "photo.v2", "application/pdf", "photo.v2.pdf"},
+		{"no extension, plain text", "LICENSE", "text/plain; charset=utf-8", "LICENSE"},
+		{"unknown type", "data.bin", "application/x-tinyupload-unknown", "data.bin"},
+		{"unknown type, no extension", "data", "application/x-tinyupload-unknown", "data"},
+		{"undetermined type", "data", "application/octet-stream", "data"},
+		{"unparsable type", "data", "not a type", "data"},
+		{"TEXT_EXTENSIONS", "server.log", "application/octet-stream", "server.log"},
+	}
+	for _, tt := range tests {
+		s := &FileServer{config: &Config{ExtensionCheck: "append", TextExtensions: []string{".log"}}}
+		if got := s.correctExtension(tt.filename, tt.mimeType); got != tt.want {
+			t.Errorf("%s: correctExtension(%q, %q) = %q, want %q", tt.name, tt.filename, tt.mimeType, got, tt.want)
+		}
+
+		// warn 与 off 只记录日志或不处理，文件名不变
+		for _, mode := range []string{"warn", "off"} {
+			s.config.ExtensionCheck = mode
+			if got := s.correctExtension(tt.filename, tt.mimeType); got != tt.filename {
+				t.Errorf("%s: EXTENSION_CHECK=%s changed %q to %q", tt.name, mode, tt.filename, got)
+			}
+		}
+	}
+}
+
+func TestCorrectExtensionLength(t *testing.T) {
+	s := &FileServer{config: &Config{ExtensionCheck: "append"}}
+	// 追加扩展名后不超过 255 字节，也不截断多字节字符
+	filename := strings.Repeat("图", 85)
+	got := s.correctExtension(filename, "image/png")
+	if len(got) > 255 || !strings.HasSuffix(got, "图.png") {
+		t.Errorf("correctExtension(%d-byte name) = %q (%d bytes)", len(filename), got, len(got))
+	}
+}
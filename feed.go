@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 订阅源中最多列出的文件数
+const feedSize = 50
+
+// feedItem JSON Feed 1.1 中的一个条目，对应一个公开文件
+type feedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentText   string           `json:"content_text"`
+	DatePublished string           `json:"date_published"`
+	Attachments   []feedAttachment `json:"attachments"`
+}
+
+type feedAttachment struct {
+	URL         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes"`
+}
+
+// handleFeed 以 JSON Feed 格式列出最近上传的公开文件，私有文件、设有下载密码的文件与已过期的文件不会出现；
+// 需开启 FEED_ENABLED
+func (s *FileServer) handleFeed(c *fiber.Ctx) error {
+	rows, err := s.db.Query(`
+       SELECT id, path, filename, encoded_filename, file_size, COALESCE(mime_type, ''), upload_time,
+              COALESCE(description, '')
+       FROM files
+       WHERE private = 0 AND password_hash IS NULL AND (expires_at IS NULL OR expires_at > datetime('now'))
+       ORDER BY id DESC
+       LIMIT ?
+   `, feedSize)
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	defer rows.Close()
+
+	items := []feedItem{}
+	for rows.Next() {
+		var id, fileSize int64
+		var path, filename, encodedFilename, mimeType, description string
+		var uploadTime time.Time
+		if err := rows.Scan(&id, &path, &filename, &encodedFilename, &fileSize, &mimeType, &uploadTime,
+			&description); err != nil {
+			return dbUnavailable(c, err)
+		}
+		fileURL := fmt.Sprintf("%s/%s/%s", baseURL(c), path, encodedFilename)
+		text := description
+		if text == "" {
+			text = fmt.Sprintf("%s, %s", formatFileSize(fileSize), mimeType)
+		}
+		items = append(items, feedItem{
+			ID:            fmt.Sprintf("%s#%d", fileURL, id),
+			URL:           fileURL,
+			Title:         filename,
+			ContentText:   text,
+			DatePublished: uploadTime.UTC().Format(time.RFC3339),
+			Attachments: []feedAttachment{{
+				URL:         fileURL + "?raw=1",
+				MimeType:    responseContentType(filename, mimeType),
+				SizeInBytes: fileSize,
+			}},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	data, err := json.Marshal(fiber.Map{
+		"version":       "https://jsonfeed.org/version/1.1",
+		"title":         fmt.Sprintf("%s uploads", c.Hostname()),
+		"home_page_url": baseURL(c) + "/",
+		"feed_url":      baseURL(c) + "/feed",
+		"items":         items,
+	})
+	if err != nil {
+		return err
+	}
+	c.Set("Cache-Control", "public, max-age=60")
+	c.Set(fiber.HeaderContentType, "application/feed+json; charset=utf-8")
+	return c.Send(data)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"syscall"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// isReadOnlyError 判断错误是否由只读文件系统引起，例如磁盘故障后被重新挂载为只读
+func isReadOnlyError(err error) bool {
+	return errors.Is(err, syscall.EROFS)
+}
+
+// storageWriteError 把写入上传目录失败的错误转换为返回给客户端的错误：
+// 只读存储返回 503 并说明原因 (下载不受影响)，其余错误返回 500 和 msg
+func storageWriteError(err error, msg string) error {
+	if isReadOnlyError(err) {
+		log.Printf("Upload storage is read-only: %v", err)
+		return fiber.NewError(503, "Storage is read-only, uploads are temporarily unavailable")
+	}
+	log.Printf("%s: %v", msg, err)
+	return fiber.NewError(500, msg)
+}
+
+// checkStorageWritable 在上传目录中创建并删除一个临时文件，确认存储可写
+func (s *FileServer) checkStorageWritable() error {
+	f, err := os.CreateTemp(s.uploadDir, tempFilePrefix+"health-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// handleHealth 供监控使用：数据库不可用或上传目录不可写时返回 503，
+// storage 为 read-only 时说明存储被挂载为只读，此时仍可下载但无法上传
+func (s *FileServer) handleHealth(c *fiber.Ctx) error {
+	status := 200
+	result := fiber.Map{"status": "ok", "database": "ok", "storage": "ok"}
+
+	var one int
+	if err := s.db.QueryRow("SELECT 1").Scan(&one); err != nil {
+		log.Printf("Health check: database unavailable: %v", err)
+		result["database"] = "unavailable"
+		status = 503
+	}
+	if err := s.checkStorageWritable(); err != nil {
+		log.Printf("Health check: storage not writable: %v", err)
+		result["storage"] = "unwritable"
+		if isReadOnlyError(err) {
+			result["storage"] = "read-only"
+		}
+		status = 503
+	}
+	if status != 200 {
+		result["status"] = "degraded"
+	}
+	// 上传被管理员暂停是有意为之，不影响健康状态
+	result["uploads"] = "enabled"
+	if s.uploadsDisabled.Load() {
+		result["uploads"] = "disabled"
+	}
+
+	c.Set("Cache-Control", "no-store")
+	return sendJSON(c.Status(status), result)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Idempotency-Key 头允许的最大长度
+const maxIdempotencyKeyLength = 255
+
+// idempotencyKey 读取 Idempotency-Key 头，返回与上传者 (令牌或客户端 IP) 及目标路径 (X-Upload-Path)
+// 组合后的哈希，不同客户端使用相同的键不会互相覆盖；未携带该头或未启用 IDEMPOTENCY_WINDOW 时返回空
+func (s *FileServer) idempotencyKey(c *fiber.Ctx, token *uploadTokenInfo) (string, error) {
+	key := strings.TrimSpace(c.Get("Idempotency-Key"))
+	if key == "" || s.config.IdempotencyWindow <= 0 {
+		return "", nil
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return "", fmt.Errorf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength)
+	}
+
+	scope := "ip:" + clientIP(c)
+	if token != nil {
+		scope = "token:" + strconv.FormatInt(token.id, 10)
+	}
+	sum := sha256.Sum256([]byte(scope + "\n" + c.Get("X-Upload-Path") + "\n" + key))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// idempotencyModifier 返回表示重复上传窗口起点的 SQLite datetime 修饰符，如 datetime('now', ?)
+func (s *FileServer) idempotencyModifier() string {
+	return fmt.Sprintf("-%d seconds", int64(s.config.IdempotencyWindow.Seconds()))
+}
+
+// idempotentUpload 查找窗口内以相同键上传的文件，返回其路径、存储目录与删除码；
+// 不按文件名查找：保存时的名称可能已被修正扩展名或加上编号后缀，与请求中的名称不同，
+// 是否覆盖由保存时按最终名称判断。没有找到时返回 sql.ErrNoRows
+func (s *FileServer) idempotentUpload(key string) (path, storageDir, deleteCode string, err error) {
+	err = s.db.QueryRow(`
+       SELECT path, COALESCE(storage_dir, path), delete_code FROM files
+       WHERE idempotency_key = ? AND upload_time > datetime('now', ?)
+       ORDER BY id DESC LIMIT 1
+   `, key, s.idempotencyModifier()).Scan(&path, &storageDir, &deleteCode)
+	return path, storageDir, deleteCode, err
+}
@@ -1,33 +1,156 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math/big"
 	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/compress"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// expiryModifier 返回表示一段保留时长的 SQLite datetime 修饰符，如 datetime('now', ?)；
+// 新文件的 expires_at 为上传时间加上该时长
+func expiryModifier(d time.Duration) string {
+	return fmt.Sprintf("+%d seconds", int64(d.Seconds()))
+}
+
+// formatExpiry 以天、小时或分钟中能整除的最大单位显示保留时长，用于页面提示
+func formatExpiry(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%d 天", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%d 小时", d/time.Hour)
+	default:
+		return fmt.Sprintf("%d 分钟", d/time.Minute)
+	}
+}
+
+// 内存中缓存的请求体上限，超过后以流的方式读取
+const requestBufferSize = 16 * 1024 * 1024
+
+// 响应中使用的时间格式
+const timeLayout = "2006-01-02 15:04:05"
+
+// expireTimeText 文件落地页中显示的过期时间
+func expireTimeText(expiresAt sql.NullTime) string {
+	if !expiresAt.Valid {
+		return "永不过期"
+	}
+	return expiresAt.Time.Local().Format(timeLayout)
+}
+
+// setExpiry 在响应中加入过期时间：expireTime 为本地时间，expiresAt 为 ISO 8601 格式，
+// expiresIn 为服务端计算的剩余秒数，客户端无需知道保留策略即可显示倒计时；
+// 没有过期时间 (永久保留) 的文件三者均为 null
+func setExpiry(m fiber.Map, expiresAt sql.NullTime) {
+	if !expiresAt.Valid {
+		m["expireTime"], m["expiresAt"], m["expiresIn"] = nil, nil, nil
+		return
+	}
+	remaining := int64(time.Until(expiresAt.Time).Seconds())
+	if remaining < 0 {
+		remaining = 0
+	}
+	m["expireTime"] = expiresAt.Time.Local().Format(timeLayout)
+	m["expiresAt"] = expiresAt.Time.UTC().Format(time.RFC3339)
+	m["expiresIn"] = remaining
+}
+
 type FileServer struct {
 	db        *sql.DB
 	uploadDir string
 	app       *fiber.App
+	config    *Config
+	quota     *storageQuota
+	// 服务进程内的随机密钥，用于签发删除确认令牌
+	secret   []byte
+	powGuard *powReplayGuard
+	// 下载密码的错误次数与锁定
+	passwords *passwordAttempts
+	// 全局请求速率限制，未配置 GLOBAL_RATE_LIMIT 时为 nil
+	rateLimit *tokenBucket
+	outbound  *outboundClient
+	// 最近确认不存在的文件，未配置 NOT_FOUND_CACHE_TTL 时为 nil
+	notFound *notFoundCache
+	// 每个文件当前进行中的下载数
+	downloads *downloadLimiter
+	// 正在读取的磁盘文件，删除时推迟到读取结束
+	readers *fileReaders
+	// 同一路径目录的创建、写入与删除互斥，避免删除其他请求正在使用的目录
+	pathLocks *keyedMutex
+	// 同一上传令牌的配额检查与写入互斥
+	tokenLocks *keyedMutex
+
+	cleanupLog   *log.Logger
+	cleanupMu    sync.Mutex
+	lastCleanup  *cleanupResult
+	cleanupStats cleanupMetrics
+
+	verifyMu   sync.Mutex
+	lastVerify *verifyRun
+
+	// 管理员在运行时暂停上传，下载与删除不受影响
+	uploadsDisabled atomic.Bool
+
+	// 运行时找到的 ffmpeg，为空时不生成视频封面
+	ffmpegPath string
+	posterJobs chan struct{}
+}
+
+// 旧版本数据库中缺少的列，启动时自动补齐
+var fileColumns = []struct {
+	name string
+	def  string
+}{
+	{"checksum_md5", "TEXT"},
+	{"checksum_sha1", "TEXT"},
+	{"checksum_sha256", "TEXT"},
+	{"storage_dir", "TEXT"},
+	{"content", "BLOB"},
+	{"expires_at", "DATETIME"},
+	{"stored_size", "INTEGER"},
+	{"compressed", "INTEGER NOT NULL DEFAULT 0"},
+	{"uploader_ip", "TEXT"},
+	{"description", "TEXT"},
+	{"token_id", "INTEGER"},
+	{"private", "INTEGER NOT NULL DEFAULT 0"},
+	{"access_code", "TEXT"},
+	{"idempotency_key", "TEXT"},
+	{"claimed_at", "DATETIME"},
+	{"poster", "BLOB"},
+	{"password_hash", "TEXT"},
+	{"retention_seconds", "INTEGER"},
 }
 
-func NewFileServer() (*FileServer, error) {
+func NewFileServer(config *Config) (*FileServer, error) {
 	if err := os.MkdirAll("data", 0755); err != nil {
 		return nil, fmt.Errorf("failed to create data directory: %v", err)
 	}
@@ -35,79 +158,266 @@ func NewFileServer() (*FileServer, error) {
 		return nil, fmt.Errorf("failed to create uploads directory: %v", err)
 	}
 
-	db, err := sql.Open("sqlite3", "data/files.db")
+	db, err := sql.Open("sqlite3", databaseDSN(config))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
 
-	_, err = db.Exec(`
-       CREATE TABLE IF NOT EXISTS files (
-           id INTEGER PRIMARY KEY AUTOINCREMENT,
-           path TEXT NOT NULL,
-           filename TEXT NOT NULL,
-           encoded_filename TEXT NOT NULL,
-           delete_code TEXT NOT NULL,
-           upload_time DATETIME NOT NULL,
-           file_size INTEGER NOT NULL,
-           mime_type TEXT,
-           download_count INTEGER DEFAULT 0,
-           UNIQUE(path, encoded_filename)
-       )
-   `)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create table: %v", err)
+	if err := migrateSchema(db, config.DefaultExpiry); err != nil {
+		return nil, err
+	}
+
+	var usedBytes int64
+	if err := db.QueryRow(storageUsageQuery).Scan(&usedBytes); err != nil {
+		return nil, fmt.Errorf("failed to calculate storage usage: %v", err)
 	}
 
+	// 开启流式请求体后，超过 BodyLimit 的请求不会被直接拒绝，而是以流的形式交给处理函数，
+	// 文件大小由 MAX_FILE_SIZE 和存储配额在上传处理中限制
 	app := fiber.New(fiber.Config{
-		Prefork:                 false,
+		Prefork:                 config.Prefork,
 		ServerHeader:            "FileServer",
-		BodyLimit:               1024 * 1024 * 1024,
+		StrictRouting:           !config.NormalizeURLs,
+		BodyLimit:               requestBufferSize,
+		StreamRequestBody:       true,
 		ReadTimeout:             30 * time.Second,
-		WriteTimeout:            30 * time.Second,
+		WriteTimeout:            config.WriteTimeout,
 		IdleTimeout:             60 * time.Second,
-		ProxyHeader:             "X-Real-IP",
+		ProxyHeader:             config.ProxyHeader,
 		EnableTrustedProxyCheck: true,
-		TrustedProxies:          []string{"127.0.0.1", "::1", "172.17.0.1", "192.168.1.8"},
+		TrustedProxies:          config.TrustedProxies,
+		EnableIPValidation:      true,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			log.Printf("Error: %v", err)
 			return c.Redirect("/", 302)
 		},
 	})
 
+	// fiber 未开放该项，直接设置底层的 fasthttp 服务；按连接的来源地址计数 (只支持 IPv4)，
+	// 在反向代理之后时计数的是代理的地址，因此默认不启用
+	app.Server().MaxConnsPerIP = config.MaxConnsPerIP
+
 	app.Use(logger.New(logger.Config{
 		Next: func(c *fiber.Ctx) bool {
 			return strings.HasPrefix(c.Path(), "/static/") || c.Path() == "/favicon.ico"
 		},
 	}))
 
-	app.Use(compress.New(compress.Config{
-		Level: compress.LevelBestSpeed,
-	}))
+	app.Use(compressResponses())
 	app.Use(cors.New())
+	if config.NormalizeURLs {
+		app.Use(normalizePath)
+	}
+
+	cleanupLog := log.Default()
+	if config.CleanupLogFile != "" {
+		f, err := os.OpenFile(config.CleanupLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open cleanup log: %v", err)
+		}
+		cleanupLog = log.New(f, "", log.LstdFlags)
+	}
+
+	outbound, err := newOutboundClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := processSecret(config)
+	if err != nil {
+		return nil, err
+	}
 
 	return &FileServer{
-		db:        db,
-		uploadDir: "data/uploads",
-		app:       app,
+		db:         db,
+		uploadDir:  "data/uploads",
+		app:        app,
+		config:     config,
+		quota:      newStorageQuota(config.StorageQuotaBytes, usedBytes),
+		pathLocks:  newKeyedMutex(),
+		tokenLocks: newKeyedMutex(),
+		secret:     secret,
+		powGuard:   newPowReplayGuard(),
+		passwords:  newPasswordAttempts(config.DownloadPasswordMaxAttempts, config.DownloadPasswordLockout),
+		rateLimit:  newGlobalRateLimit(config),
+		notFound:   newNotFoundCache(config),
+		outbound:   outbound,
+		downloads:  newDownloadLimiter(),
+		readers:    newFileReaders(),
+
+		cleanupLog: cleanupLog,
+
+		ffmpegPath: findFFmpeg(config),
+		posterJobs: make(chan struct{}, maxPosterJobs),
 	}, nil
 }
 
+// migrateSchema 创建数据表并补齐旧版本数据库中缺少的列与索引，
+// defaultExpiry 用于为没有过期时间的旧记录补齐过期时间
+func migrateSchema(db *sql.DB, defaultExpiry time.Duration) error {
+	_, err := db.Exec(`
+       CREATE TABLE IF NOT EXISTS files (
+           id INTEGER PRIMARY KEY AUTOINCREMENT,
+           path TEXT NOT NULL,
+           filename TEXT NOT NULL,
+           encoded_filename TEXT NOT NULL,
+           delete_code TEXT NOT NULL,
+           upload_time DATETIME NOT NULL,
+           file_size INTEGER NOT NULL,
+           mime_type TEXT,
+           download_count INTEGER DEFAULT 0,
+           UNIQUE(path, encoded_filename)
+       )
+   `)
+	if err != nil {
+		return fmt.Errorf("failed to create table: %v", err)
+	}
+	if _, err := db.Exec(uploadTokensTable); err != nil {
+		return fmt.Errorf("failed to create upload tokens table: %v", err)
+	}
+	if _, err := db.Exec(deleteTokensTable); err != nil {
+		return fmt.Errorf("failed to create delete tokens table: %v", err)
+	}
+
+	for _, col := range fileColumns {
+		added, err := ensureColumn(db, "files", col.name, col.def)
+		if err != nil {
+			return fmt.Errorf("failed to migrate column %s: %v", col.name, err)
+		}
+		// 旧记录没有过期时间，按上传时间加保留期补齐；之后为 NULL 的记录表示永久保留
+		if added && col.name == "expires_at" {
+			if _, err := db.Exec("UPDATE files SET expires_at = datetime(upload_time, ?)", expiryModifier(defaultExpiry)); err != nil {
+				return fmt.Errorf("failed to backfill expiry times: %v", err)
+			}
+		}
+		// 旧记录的保留时长按过期时间与上传时间之差补齐，SLIDING_EXPIRY 顺延时使用
+		if added && col.name == "retention_seconds" {
+			if _, err := db.Exec(`UPDATE files SET retention_seconds = strftime('%s', expires_at) - strftime('%s', upload_time)
+                                  WHERE expires_at IS NOT NULL`); err != nil {
+				return fmt.Errorf("failed to backfill retention times: %v", err)
+			}
+		}
+	}
+
+	// 按令牌统计占用时使用
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_files_token_id ON files (token_id)"); err != nil {
+		return fmt.Errorf("failed to create index: %v", err)
+	}
+	// 按 Idempotency-Key 查找重复上传时使用
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_files_idempotency_key ON files (idempotency_key)"); err != nil {
+		return fmt.Errorf("failed to create index: %v", err)
+	}
+	return nil
+}
+
+// ensureColumn 在列不存在时补齐，返回是否新增了该列
+func ensureColumn(db *sql.DB, table, column, def string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name, typ  string
+			notNull    int
+			dfltValue  sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dfltValue, &primaryKey); err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def))
+	return err == nil, err
+}
+
 func (s *FileServer) setupRoutes() {
+	// 全局限速最先执行，超出时不再进入其他处理
+	if s.rateLimit != nil {
+		s.app.Use(s.globalRateLimit)
+	}
 	s.app.Static("/static", "./static")
 	s.app.Get("/favicon.ico", func(c *fiber.Ctx) error {
 		return c.SendStatus(204)
 	})
+	s.app.Get("/robots.txt", func(c *fiber.Ctx) error {
+		return c.Type("text").SendString("User-agent: *\nDisallow: /\n")
+	})
+	s.app.Get("/challenge", s.handleChallenge)
+	s.app.Get("/health", s.handleHealth)
+	if s.config.MetricsEnabled {
+		s.app.Get("/metrics", s.handleMetrics)
+	}
+	if s.config.FeedEnabled {
+		s.app.Get("/feed", s.handleFeed)
+	}
+	s.app.Head("/:filename?", s.handleUploadOptions)
 	s.app.Get("/", s.handleRoot)
-	s.app.Put("/:filename", s.handleUpload)
+	// 需先于 POST 上传路由注册，否则 /delete 会被当作文件名
+	s.app.Post("/delete", s.handleBatchDelete)
+	s.app.Put("/:filename?", s.handleUpload)
+	s.app.Post("/:filename?", s.handleUpload)
+	s.app.Get("/owner/:path/:filename", s.handleOwnerInfo)
+	s.app.Get("/preview/:path/:filename", s.handlePreview)
+	s.app.Get("/thumbnail/:path/:filename", s.handleThumbnail)
+	s.app.Get("/api/paths", s.requireAdmin, s.handleListPaths)
+	s.app.Get("/api/recent", s.requireAdmin, s.handleRecentUploads)
+	s.app.Get("/api/size-histogram", s.requireAdmin, s.handleSizeHistogram)
+	s.app.Get("/api/mime-usage", s.requireAdmin, s.handleMimeUsage)
+	s.app.Get("/api/ip/:ip", s.requireAdmin, s.handleUploaderStats)
+	s.app.Delete("/api/ip/:ip", s.requireAdmin, s.handleUploaderDelete)
+	s.app.Get("/api/cleanup", s.requireAdmin, s.handleCleanupStatus)
+	s.app.Get("/admin/uploads", s.requireAdmin, s.handleUploadsStatus)
+	s.app.Post("/admin/uploads/disable", s.requireAdmin, s.handleUploadsToggle(false))
+	s.app.Post("/admin/uploads/enable", s.requireAdmin, s.handleUploadsToggle(true))
+	s.app.Post("/admin/tokens", s.requireAdmin, s.handleCreateToken)
+	s.app.Get("/admin/tokens", s.requireAdmin, s.handleListTokens)
+	s.app.Delete("/admin/tokens/:id", s.requireAdmin, s.handleRevokeToken)
+	s.app.Get("/admin/tokens/:id/usage", s.requireAdmin, s.handleTokenUsage)
+	s.app.Post("/admin/delete-tokens/:path/:filename", s.requireAdmin, s.handleCreateDeleteToken)
+	s.app.Get("/admin/delete-tokens/:path/:filename", s.requireAdmin, s.handleListDeleteTokens)
+	s.app.Delete("/admin/delete-tokens/:id", s.requireAdmin, s.handleRevokeDeleteToken)
+	s.app.Post("/admin/queue/next", s.requireAdmin, s.handleQueueNext)
+	s.app.Get("/admin/export", s.requireAdmin, s.handleExport)
+	s.app.Post("/admin/import", s.requireAdmin, s.handleImport)
+	s.app.Post("/admin/verify", s.requireAdmin, s.handleVerifyAll)
+	s.app.Get("/admin/verify", s.requireAdmin, s.handleVerifyStatus)
+	s.app.Post("/admin/verify/:path/:filename", s.requireAdmin, s.handleVerifyFile)
+	if s.config.ShortLinks {
+		s.app.Get("/d/:id", s.handleShortLink)
+	}
+	if s.config.SignedURLSecret != "" {
+		s.app.Get("/s/:token/:filename", s.handleSignedDownload)
+	}
 	s.app.Get("/:path/:filename", s.handleDownload)
+	s.app.Get("/delete/:path/:filename", s.handleDeleteConfirm)
 	s.app.Delete("/delete/:path/:filename", s.handleDelete)
 
 	s.app.Use(func(c *fiber.Ctx) error {
+		// 浏览器访问不存在的地址时显示 404 页面，而不是跳回首页让人困惑
+		if isBrowser(c) {
+			return s.fileNotFound(c)
+		}
 		return c.Redirect("/", 302)
 	})
 }
 
 func (s *FileServer) handleRoot(c *fiber.Ctx) error {
+	// 同一地址按 Accept 与 User-Agent 返回不同内容，避免缓存混用
+	c.Vary(fiber.HeaderAccept, fiber.HeaderUserAgent)
+	if prefersJSON(c) {
+		return s.handleAPIDescription(c)
+	}
 	if isTextPreferred(c) {
 		host := c.Hostname()
 		now := time.Now().Format("2006-01-02 15:04:05")
@@ -116,289 +426,1504 @@ func (s *FileServer) handleRoot(c *fiber.Ctx) error {
 Upload File:
  curl -T filename %s
  curl -T filename %s/new_filename
+ curl -T filename "%s/?filename=new_filename"
 
 Download File:
  curl -O %s/xxxx/filename
  wget %s/xxxx/filename
 
 Delete File:
- curl -X DELETE "%s/delete/xxxx/filename?code=delete_code"
+ curl -X DELETE -H "Authorization: Bearer delete_code" %s/delete/xxxx/filename
+ curl -X DELETE "%s/delete/xxxx/filename?code=delete_code"  (legacy, code ends up in logs)
 
 Server Time: %s
-`, host, host, host, host, host, now))
+`, host, host, host, host, host, host, host, now))
 	}
 	return c.Render("static/index.html", fiber.Map{
-		"ServerHost": c.Hostname(),
-		"Protocol":   c.Protocol(),
+		"ServerHost":  c.Hostname(),
+		"Protocol":    c.Protocol(),
+		"MaxFileSize": s.config.MaxFileSize,
+		"Challenge":   s.config.UploadChallenge,
+		// 无需挑战与上传令牌时，禁用脚本的浏览器可通过普通表单上传
+		"UploadForm":  s.config.UploadChallenge == "none" && !s.config.RequireUploadToken,
+		"UploadField": s.uploadFormField(),
 	})
 }
 
 func (s *FileServer) handleUpload(c *fiber.Ctx) error {
+	token, err := s.uploadToken(c)
+	if err != nil {
+		return respondError(c, err)
+	}
+	// 持有上传令牌的 API 客户端无需通过反滥用挑战
+	if token == nil {
+		if err := s.checkUploadChallenge(c); err != nil {
+			return c.Status(403).SendString(err.Error())
+		}
+	}
+	if s.uploadsDisabled.Load() {
+		c.Set("Retry-After", "300")
+		return c.Status(503).SendString("Uploads are temporarily disabled")
+	}
+	if s.diskPressure() {
+		return c.Status(507).SendString("Insufficient free disk space, uploads are temporarily disabled")
+	}
+
 	filename := c.Params("filename")
 	decodedFilename, err := url.QueryUnescape(filename)
 	if err != nil {
 		return c.Status(400).SendString("Invalid filename")
 	}
 
-	if decodedFilename == "" {
-		if cd := c.Get("Content-Disposition"); cd != "" {
-			if _, params, err := mime.ParseMediaType(cd); err == nil {
-				if fn := params["filename"]; fn != "" {
-					decodedFilename = fn
-				}
-			}
+	// HTML 表单等客户端以 multipart/form-data 上传，从配置的字段中取出文件
+	var formFiles []*multipart.FileHeader
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		if formFiles, err = s.uploadFormFiles(c); err != nil {
+			return c.Status(400).SendString(err.Error())
 		}
+	}
+
+	// 一次上传多个文件时各自使用表单中的文件名
+	if len(formFiles) <= 1 {
+		decodedFilename = s.uploadFilename(c, decodedFilename, formFiles)
 		if decodedFilename == "" {
 			return c.Status(400).SendString("No filename specified")
 		}
 	}
 
-	// 清理文件名以防止路径遍历攻击
-	decodedFilename = sanitizeFilename(decodedFilename)
-	if decodedFilename == "" {
-		return c.Status(400).SendString("Invalid filename after sanitization")
+	// 拒绝包含路径的文件名以防止路径遍历攻击
+	if len(formFiles) <= 1 {
+		var ok bool
+		if decodedFilename, ok = safeRequestFilename(decodedFilename); !ok {
+			return c.Status(400).SendString("Invalid filename")
+		}
 	}
 
-	path := generateRandomPath()
-	dirPath := filepath.Join(s.uploadDir, path)
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		return c.Status(500).SendString("Failed to create directory")
+	// X-Upload-Path 指定已有路径时，凭该路径下文件的删除码把新文件放到同一路径
+	path := c.Get("X-Upload-Path")
+	var pathCode, storageDir string
+	if path != "" {
+		if !s.config.PathReuse {
+			return c.Status(400).SendString("Path reuse is disabled")
+		}
+		pathCode, err = deleteCodeFromRequest(c)
+		if err != nil || pathCode == "" {
+			return c.Status(401).SendString("Delete code required to upload into an existing path")
+		}
+		storageDir, err = s.reusablePath(path, pathCode)
+		if err == sql.ErrNoRows {
+			return c.Status(403).SendString("Invalid path or delete code")
+		}
+		if err != nil {
+			return dbUnavailable(c, err)
+		}
 	}
 
-	encodedFilename := url.QueryEscape(decodedFilename)
-	log.Printf("Saving to DB - path: %s, filename: %s, encoded: %s", path, decodedFilename, encodedFilename)
+	deleteCode := c.Get("X-Delete-Code")
+	if deleteCode != "" {
+		if err := s.validateDeleteCode(deleteCode); err != nil {
+			return c.Status(400).SendString(err.Error())
+		}
+	} else if pathCode != "" {
+		// 同一路径下的文件默认共用删除码，便于批量管理
+		deleteCode = pathCode
+	} else {
+		deleteCode = generateRandomString(s.generatedDeleteCodeLength())
+	}
 
-	filePath := filepath.Join(dirPath, decodedFilename)
-	fileContent := c.Body()
-	if len(fileContent) == 0 {
-		return c.Status(400).SendString("Empty file content")
+	description, err := s.uploadDescription(c)
+	if err != nil {
+		return c.Status(400).SendString(err.Error())
+	}
+	private := false
+	if v := c.Get("X-Private"); v != "" {
+		if private, err = strconv.ParseBool(v); err != nil {
+			return c.Status(400).SendString("Invalid X-Private header, expected true or false")
+		}
+	}
+	expiry, err := s.uploadExpiry(c)
+	if err != nil {
+		return c.Status(400).SendString(err.Error())
+	}
+	passwordHash, err := uploadPassword(c)
+	if err != nil {
+		return c.Status(400).SendString(err.Error())
+	}
+	meta := uploadMeta{uploaderIP: clientIP(c), description: description, token: token, private: private, expiry: expiry,
+		passwordHash: passwordHash}
+
+	// 窗口内以相同 Idempotency-Key 重复上传同名文件 (如超时后重试) 时覆盖之前的文件，
+	// 沿用其路径与删除码；一次上传多个文件时不适用
+	if len(formFiles) <= 1 {
+		if meta.idempotencyKey, err = s.idempotencyKey(c, token); err != nil {
+			return c.Status(400).SendString(err.Error())
+		}
+	}
+	if meta.idempotencyKey != "" {
+		prevPath, prevDir, prevCode, err := s.idempotentUpload(meta.idempotencyKey)
+		if err == nil {
+			path, storageDir, deleteCode = prevPath, prevDir, prevCode
+		} else if err != sql.ErrNoRows {
+			return dbUnavailable(c, err)
+		}
 	}
 
-	if err := os.WriteFile(filePath, fileContent, 0644); err != nil {
-		return c.Status(500).SendString("Failed to save file")
+	if max := s.config.MaxFileSize; max > 0 && formFiles == nil && int64(c.Request().Header.ContentLength()) > max {
+		return c.Status(413).SendString(fmt.Sprintf("File too large, maximum size is %d bytes", max))
 	}
 
-	fileSize := int64(len(fileContent))
-	mimeType := c.Get("Content-Type")
-	if mimeType == "" {
-		mimeType = mime.TypeByExtension(filepath.Ext(decodedFilename))
-		if mimeType == "" {
-			mimeType = http.DetectContentType(fileContent)
+	if path == "" {
+		if path, err = s.newPath(); err != nil {
+			return dbUnavailable(c, err)
 		}
+		storageDir = s.storageDirFor(path)
 	}
 
-	deleteCode := generateRandomString(8)
+	if len(formFiles) > 1 {
+		return s.uploadFormBatch(c, path, storageDir, deleteCode, meta, formFiles)
+	}
 
-	_, err = s.db.Exec(`
-       INSERT INTO files (path, filename, encoded_filename, delete_code, upload_time, file_size, mime_type)
-       VALUES (?, ?, ?, ?, datetime('now'), ?, ?)
-   `, path, decodedFilename, encodedFilename, deleteCode, fileSize, mimeType)
+	// 内容边接收边写入临时文件，不在内存中缓存整个文件
+	var upload *spooledUpload
+	mimeType := c.Get("Content-Type")
+	if len(formFiles) == 1 {
+		if upload, err = s.spoolFormFile(formFiles[0]); err != nil {
+			return respondError(c, err)
+		}
+		mimeType = formFiles[0].Header.Get("Content-Type")
+	} else if upload, err = s.spoolRequestBody(c); err != nil {
+		return respondError(c, err)
+	}
 
+	f, err := s.storeUpload(path, storageDir, decodedFilename, deleteCode, meta, upload, mimeType)
 	if err != nil {
-		os.Remove(filePath)
-		return c.Status(500).SendString("Failed to save file information")
+		return respondError(c, err)
 	}
 
-	if isTextPreferred(c) {
-		return c.Type("text").SendString(fmt.Sprintf(`Upload successful!
+	format := uploadResponseFormat(c)
+	if format == "html" {
+		return s.renderUploadResult(c, s.config.UploadSuccessStatus, deleteCode, []*storedFile{f}, nil)
+	}
+	if format == "csv" {
+		c.Location(fmt.Sprintf("/%s/%s", path, f.encodedFilename))
+		return sendCSV(c.Status(s.config.UploadSuccessStatus),
+			[][]string{f.csvRecord(c, deleteCode, s.config.UploadSuccessStatus)})
+	}
+	if format == "text" {
+		text := fmt.Sprintf(`Upload successful!
 Filename: %s
-Access URL: http://%s/%s/%s
+Access URL: %s
 Delete Code: %s
 Size: %d bytes
 Type: %s
+Expires: %s
 
 Delete Command:
-curl -X DELETE "http://%s/delete/%s/%s?code=%s"
+curl -X DELETE -H "Authorization: Bearer %s" "%s/delete/%s/%s"
 `,
-			decodedFilename,
-			c.Hostname(), path, encodedFilename,
+			f.filename,
+			f.url(c),
 			deleteCode,
-			fileSize, mimeType,
-			c.Hostname(), path, encodedFilename, deleteCode,
-		))
+			f.size, f.mimeType,
+			f.expiresAt.Format(timeLayout),
+			deleteCode, baseURL(c), path, f.encodedFilename,
+		)
+		if f.description != "" {
+			text += fmt.Sprintf("\nDescription: %s\n", f.description)
+		}
+		if f.protected {
+			text += "\nPassword protected, downloads require the download password.\n"
+		}
+		if f.accessCode != "" {
+			text += fmt.Sprintf("\nPrivate file, downloads require the access code or the delete code.\nAccess Code: %s\n", f.accessCode)
+		}
+		if f.shortID != "" {
+			text += fmt.Sprintf("\nShort URL: %s/d/%s%s\n", baseURL(c), f.shortID, f.accessQuery())
+		}
+		if f.signedToken != "" {
+			text += fmt.Sprintf("\nSigned URL: %s\n", f.signedURL(c))
+		}
+		return c.Type("text").SendString(text)
 	}
 
-	return c.JSON(fiber.Map{
-		"path":       path,
-		"filename":   decodedFilename,
+	c.Location(fmt.Sprintf("/%s/%s", path, f.encodedFilename))
+	return sendJSON(c.Status(s.config.UploadSuccessStatus), f.toJSON(c, deleteCode))
+}
+
+// storedFile 一次成功保存的上传
+type storedFile struct {
+	path            string
+	filename        string
+	encodedFilename string
+	size            int64
+	mimeType        string
+	checksums       map[string]string
+	description     string
+	// 私有文件的访问码，公开文件为空
+	accessCode string
+	// 开启短链接时为 base62 编码的记录 id
+	shortID string
+	// 配置 SIGNED_URL_SECRET 且文件存储在磁盘上时的签名下载令牌
+	signedToken string
+	expiresAt   time.Time
+	// 是否设有下载密码
+	protected bool
+}
+
+// toJSON 上传成功时返回给 JSON 客户端的字段
+func (f *storedFile) toJSON(c *fiber.Ctx, deleteCode string) fiber.Map {
+	m := fiber.Map{
+		"path":       f.path,
+		"filename":   f.filename,
 		"deleteCode": deleteCode,
-		"size":       fileSize,
-		"mimeType":   mimeType,
+		"deleteUrl": fmt.Sprintf("%s/delete/%s/%s?code=%s",
+			baseURL(c), f.path, f.encodedFilename, url.QueryEscape(deleteCode)),
+		"size":       f.size,
+		"mimeType":   f.mimeType,
+		"checksums":  f.checksums,
 		"uploadTime": time.Now().Format("2006-01-02 15:04:05"),
-	})
+	}
+	setExpiry(m, sql.NullTime{Time: f.expiresAt, Valid: true})
+	if f.description != "" {
+		m["description"] = f.description
+	}
+	if f.protected {
+		m["passwordProtected"] = true
+	}
+	if f.accessCode != "" {
+		m["private"] = true
+		m["accessCode"] = f.accessCode
+		m["accessUrl"] = f.url(c)
+	}
+	if f.shortID != "" {
+		m["shortUrl"] = fmt.Sprintf("%s/d/%s%s", baseURL(c), f.shortID, f.accessQuery())
+	}
+	if f.signedToken != "" {
+		m["signedUrl"] = f.signedURL(c)
+	}
+	return m
 }
 
-func (s *FileServer) handleDownload(c *fiber.Ctx) error {
-	path := c.Params("path")
-	requestFilename := c.Params("filename")
+// signedURL 无需查询数据库的签名下载地址
+func (f *storedFile) signedURL(c *fiber.Ctx) string {
+	return fmt.Sprintf("%s/s/%s/%s", baseURL(c), f.signedToken, f.encodedFilename)
+}
 
-	decodedRequestFilename, err := url.QueryUnescape(requestFilename)
-	if err != nil {
-		return c.Status(404).SendString(`File not found`)
+// accessQuery 私有文件的链接需附带的访问码查询参数，公开文件为空
+func (f *storedFile) accessQuery() string {
+	if f.accessCode == "" {
+		return ""
 	}
+	return "?code=" + url.QueryEscape(f.accessCode)
+}
 
-	// 清理文件名以防止路径遍历攻击
-	decodedRequestFilename = sanitizeFilename(decodedRequestFilename)
-	if decodedRequestFilename == "" {
-		return c.Status(404).SendString("File not found")
+// url 文件的访问地址，私有文件附带访问码
+func (f *storedFile) url(c *fiber.Ctx) string {
+	return fmt.Sprintf("%s/%s/%s%s", baseURL(c), f.path, f.encodedFilename, f.accessQuery())
+}
+
+// csvRecord 上传结果中该文件的一行 CSV，列与 uploadCSVHeader 对应
+func (f *storedFile) csvRecord(c *fiber.Ctx, deleteCode string, status int) []string {
+	return []string{
+		f.filename,
+		f.url(c),
+		deleteCode,
+		strconv.FormatInt(f.size, 10),
+		strconv.Itoa(status),
+		"",
 	}
+}
 
-	encodedRequestFilename := url.QueryEscape(decodedRequestFilename)
+// uploadMeta 随上传请求给出、与同一请求中的每个文件一起保存的附加信息
+type uploadMeta struct {
+	uploaderIP  string
+	description string
+	// 上传所用的令牌，未使用令牌时为 nil
+	token *uploadTokenInfo
+	// 私有文件下载时需提供访问码或删除码
+	private bool
+	// 与上传者及目标路径组合后的 Idempotency-Key 哈希，未携带时为空
+	idempotencyKey string
+	// 文件的保留时长，未指定时为 DEFAULT_EXPIRY
+	expiry time.Duration
+	// 下载密码的哈希，未设置密码时为空
+	passwordHash string
+}
 
-	var originalFilename string
-	err = s.db.QueryRow("SELECT filename FROM files WHERE path = ? AND encoded_filename = ?",
-		path, encodedRequestFilename).Scan(&originalFilename)
-	if err != nil {
-		return c.Status(404).SendString("File not found")
+// tokenID 写入记录的令牌 id，未使用令牌时为 NULL
+func (m uploadMeta) tokenID() interface{} {
+	if m.token == nil {
+		return nil
 	}
+	return m.token.id
+}
 
-	filePath := filepath.Join(s.uploadDir, path, originalFilename)
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return c.Status(404).SendString("File not found")
+// uploadMimeType 确定上传文件的类型：客户端给出的 Content-Type 优先，其次按扩展名，最后识别内容开头 head。
+// 既没有 Content-Type 也没有扩展名、内容又无法识别的文件只能得到 application/octet-stream，
+// 需要类型明确的实例可开启 REQUIRE_CONTENT_TYPE 以 400 拒绝这类上传
+func (s *FileServer) uploadMimeType(filename, mimeType string, head []byte) (string, error) {
+	// 客户端未给出具体类型时，TEXT_EXTENSIONS 中的扩展名按纯文本保存，下载时可在浏览器中直接查看
+	if (mimeType == "" || mimeType == fiber.MIMEOctetStream) && s.isTextExtension(filename) {
+		mimeType = fiber.MIMETextPlainCharsetUTF8
 	}
-
-	_, err = s.db.Exec("UPDATE files SET download_count = download_count + 1 WHERE path = ? AND encoded_filename = ?",
-		path, encodedRequestFilename)
-	if err != nil {
-		log.Printf("Error updating download count: %v", err)
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(filepath.Ext(filename))
+		if mimeType == "" {
+			mimeType = http.DetectContentType(head)
+		}
 	}
-
-	return c.SendFile(filePath)
+	if s.config.RequireContentType {
+		if mediaType, _, _ := mime.ParseMediaType(mimeType); mediaType == fiber.MIMEOctetStream {
+			return "", fiber.NewError(400, "Could not determine the file type, send a Content-Type header or use a file extension")
+		}
+	}
+	return mimeType, nil
 }
 
-func (s *FileServer) handleDelete(c *fiber.Ctx) error {
-	path := c.Params("path")
-	requestFilename := c.Params("filename")
-	encodedDeleteCode := c.Query("code")
+// storeUpload 把已写入临时文件的上传保存到路径下并写入记录，每个文件单独占用配额；
+// 临时文件移动到最终位置，或在出错时删除。
+// 返回的 *fiber.Error 带有应答给客户端的状态码，其他错误表示数据库不可用
+func (s *FileServer) storeUpload(path, storageDir, filename, deleteCode string, meta uploadMeta, upload *spooledUpload, mimeType string) (*storedFile, error) {
+	// 保存成功后 tempPath 置空，其余情况删除临时文件；写入临时文件时预留的配额在失败时释放
+	tempPath := upload.tempPath
+	committed := false
+	defer func() {
+		if tempPath != "" {
+			os.Remove(tempPath)
+		}
+		if !committed {
+			upload.release()
+		}
+	}()
 
-	decodedFilename, err := url.QueryUnescape(requestFilename)
-	if err != nil {
-		return c.Status(404).SendString("File not found")
+	if upload.size == 0 {
+		return nil, fiber.NewError(400, "Empty file content")
 	}
-
-	// 清理文件名以防止路径遍历攻击
-	decodedFilename = sanitizeFilename(decodedFilename)
-	if decodedFilename == "" {
-		return c.Status(404).SendString("File not found")
+	fileSize := upload.size
+	if max := s.config.MaxFileSize; max > 0 && fileSize > max {
+		return nil, fiber.NewError(413, fmt.Sprintf("File too large, maximum size is %d bytes", max))
 	}
 
-	encodedFilename := url.QueryEscape(decodedFilename)
-
-	decodedDeleteCode, err := url.QueryUnescape(encodedDeleteCode)
+	mimeType, err := s.uploadMimeType(filename, mimeType, upload.head)
 	if err != nil {
-		return c.Status(400).SendString("Invalid delete code")
+		return nil, err
+	}
+	// 在处理重名之前修正扩展名，加上编号后缀的文件名同样带有正确的扩展名
+	filename = s.correctExtension(filename, mimeType)
+
+	unlockPath := s.pathLocks.Lock(path)
+	defer unlockPath()
+
+	// 同名文件已存在时返回 409，除非是窗口内以相同 Idempotency-Key 的重复上传，此时覆盖该文件；
+	// NAME_CONFLICT=suffix 时依次尝试加上编号后缀的文件名，直到找到未被占用的名称
+	var (
+		replaceID, replacedSize  int64
+		replacedBlob, idempotent bool
+		replacedAccessCode       string
+	)
+	original := filename
+	encodedFilename := url.QueryEscape(filename)
+	for n := 1; ; n++ {
+		replaceID, replacedSize, replacedBlob, replacedAccessCode, idempotent = 0, 0, false, "", false
+		err := s.db.QueryRow(`
+           SELECT id, COALESCE(stored_size, file_size), content IS NOT NULL, COALESCE(access_code, ''),
+                  idempotency_key IS NOT NULL AND idempotency_key = ? AND upload_time > datetime('now', ?)
+           FROM files WHERE path = ? AND encoded_filename = ?
+       `, meta.idempotencyKey, s.idempotencyModifier(), path, encodedFilename).
+			Scan(&replaceID, &replacedSize, &replacedBlob, &replacedAccessCode, &idempotent)
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if meta.idempotencyKey != "" && idempotent {
+			break
+		}
+		if s.config.NameConflict != "suffix" || n > maxNameSuffix {
+			return nil, fiber.NewError(409, "A file with this name already exists in the path")
+		}
+		filename = suffixedFilename(original, s.config.NameConflictSuffix, n)
+		encodedFilename = url.QueryEscape(filename)
+	}
+	log.Printf("Saving to DB - path: %s, filename: %s, encoded: %s", path, filename, encodedFilename)
+
+	// 校验值与 file_size 始终对应原始内容；开启 COMPRESS_TEXT 时文本类文件以 gzip 存储，
+	// stored_size 记录实际存储的字节数，配额按其计算
+	sums := upload.sums
+	storedSize, compressed := fileSize, false
+	if s.config.CompressText && isCompressibleType(mimeType) {
+		gzPath, gzSize, err := gzipFile(tempPath)
+		switch {
+		case err != nil:
+			log.Printf("Failed to compress %s/%s, storing it uncompressed: %v", path, filename, err)
+		case gzSize < fileSize:
+			os.Remove(tempPath)
+			tempPath, storedSize, compressed = gzPath, gzSize, true
+		default:
+			os.Remove(gzPath)
+		}
 	}
 
-	var filename string
-	err = s.db.QueryRow(
-		"SELECT filename FROM files WHERE path = ? AND encoded_filename = ? AND delete_code = ?",
-		path, encodedFilename, decodedDeleteCode,
-	).Scan(&filename)
+	var accessCode string
+	if meta.private {
+		// 覆盖私有文件时沿用原访问码，重试前发出的链接依然有效
+		accessCode = replacedAccessCode
+		if accessCode == "" {
+			accessCode = generateRandomString(s.generatedDeleteCodeLength())
+		}
+	}
 
+	unlockToken, err := s.reserveTokenQuota(meta.token, storedSize)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Status(403).SendString("Invalid delete code")
+		return nil, err
+	}
+	defer unlockToken()
+
+	// 小文件直接存入数据库，无需持久化文件系统；其余文件在记录写入后从临时文件移动到最终位置
+	var filePath string
+	var blob interface{}
+	if s.config.BlobMaxSize > 0 && storedSize <= s.config.BlobMaxSize {
+		content, err := os.ReadFile(tempPath)
+		if err != nil {
+			return nil, storageWriteError(err, "Failed to save file")
+		}
+		blob = content
+	} else {
+		dirPath := filepath.Join(s.uploadDir, storageDir)
+		filePath = filepath.Join(dirPath, filename)
+		if !withinDir(s.uploadDir, filePath) {
+			return nil, fiber.NewError(400, "Invalid filename")
+		}
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			return nil, storageWriteError(err, "Failed to create directory")
 		}
-		return c.Status(500).SendString("Internal server error")
 	}
 
-	filePath := filepath.Join(s.uploadDir, path, filename)
-	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
-		log.Printf("Error deleting file: %v", err)
+	// 记录在事务中写入，文件移动到最终位置后才提交：移动失败时回滚，
+	// 新上传不留下指向不存在文件的记录，覆盖时之前的记录与文件都保持原样
+	expiresAt := time.Now().Add(meta.expiry)
+	var tx *sql.Tx
+	var result sql.Result
+	if replaceID > 0 {
+		// 覆盖时保留记录 id (短链接不变) 与下载次数，其余字段按新内容更新
+		tx, result, err = s.beginWithRetry(`
+           UPDATE files SET upload_time = datetime('now'), expires_at = datetime('now', ?), file_size = ?, mime_type = ?,
+                            checksum_md5 = ?, checksum_sha1 = ?, checksum_sha256 = ?, content = ?, stored_size = ?,
+                            compressed = ?, uploader_ip = ?, description = ?, token_id = ?, private = ?, access_code = ?,
+                            password_hash = ?, retention_seconds = ?, poster = NULL
+           WHERE id = ?
+       `, expiryModifier(meta.expiry), fileSize, mimeType,
+			nullIfEmpty(sums["md5"]), nullIfEmpty(sums["sha1"]), nullIfEmpty(sums["sha256"]), blob, storedSize,
+			compressed, nullIfEmpty(meta.uploaderIP), nullIfEmpty(meta.description), meta.tokenID(), meta.private,
+			nullIfEmpty(accessCode), nullIfEmpty(meta.passwordHash), int64(meta.expiry.Seconds()), replaceID)
+	} else {
+		tx, result, err = s.beginWithRetry(`
+           INSERT INTO files (path, filename, encoded_filename, delete_code, upload_time, expires_at, file_size, mime_type,
+                              checksum_md5, checksum_sha1, checksum_sha256, storage_dir, content, stored_size, compressed,
+                              uploader_ip, description, token_id, private, access_code, idempotency_key, password_hash,
+                              retention_seconds)
+           VALUES (?, ?, ?, ?, datetime('now'), datetime('now', ?), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+       `, path, filename, encodedFilename, deleteCode, expiryModifier(meta.expiry), fileSize, mimeType,
+			nullIfEmpty(sums["md5"]), nullIfEmpty(sums["sha1"]), nullIfEmpty(sums["sha256"]), storageDir, blob,
+			storedSize, compressed, nullIfEmpty(meta.uploaderIP), nullIfEmpty(meta.description), meta.tokenID(),
+			meta.private, nullIfEmpty(accessCode), nullIfEmpty(meta.idempotencyKey), nullIfEmpty(meta.passwordHash),
+			int64(meta.expiry.Seconds()))
 	}
-
-	_, err = s.db.Exec(
-		"DELETE FROM files WHERE path = ? AND encoded_filename = ? AND delete_code = ?",
-		path, encodedFilename, decodedDeleteCode,
-	)
 	if err != nil {
-		return c.Status(500).SendString("Failed to delete file record")
+		return nil, err
+	}
+	if filePath != "" {
+		// 同名文件刚被删除、仍在等待下载结束后移除时，取消移除，避免删掉新文件
+		s.readers.Cancel(filePath)
+		if err := os.Rename(tempPath, filePath); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("Failed to roll back record of %s/%s: %v", path, filename, rbErr)
+			}
+			return nil, storageWriteError(err, "Failed to save file")
+		}
+		tempPath = ""
+	}
+	if err := tx.Commit(); err != nil {
+		// 文件已移动到位而记录未写入；新上传的文件没有记录引用，删除即可，覆盖时之前的文件已被替换
+		log.Printf("Failed to commit record of %s/%s: %v", path, filename, err)
+		if filePath != "" && replaceID == 0 {
+			os.Remove(filePath)
+		}
+		return nil, err
+	}
+	s.notFound.Invalidate(path)
+	if filePath == "" && replaceID > 0 && !replacedBlob {
+		// 新内容存入数据库，删除被覆盖的磁盘文件 (正被下载时推迟到下载结束)
+		if _, err := s.readers.Remove(filepath.Join(s.uploadDir, storageDir, filename), func() {}); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove replaced file %s/%s: %v", path, filename, err)
+		}
+	}
+	s.quota.Commit(fileSize, storedSize)
+	if replaceID > 0 {
+		s.quota.Free(replacedSize)
+		log.Printf("Replaced %s/%s with a retried upload (Idempotency-Key)", path, filename)
 	}
+	committed = true
+	s.recordTokenUpload(meta.token, fileSize)
 
-	dirPath := filepath.Join(s.uploadDir, path)
-	if err := os.Remove(dirPath); err != nil {
-		log.Printf("Failed to remove directory (may not be empty): %v", err)
+	fileID := replaceID
+	if fileID == 0 {
+		fileID, _ = result.LastInsertId()
 	}
 
-	return c.Status(200).SendString("OK")
+	// 设有下载密码的文件不签发签名链接，否则持有链接即可绕过密码
+	var signedToken string
+	if s.config.SignedURLSecret != "" && blob == nil && meta.passwordHash == "" {
+		signedToken, err = s.signLocation(signedLocation{
+			StorageDir: storageDir,
+			Filename:   filename,
+			MimeType:   mimeType,
+			Compressed: compressed,
+			Size:       fileSize,
+			Expires:    expiresAt.Unix(),
+			ID:         fileID,
+			Checksum:   recordChecksum(sums),
+		})
+		if err != nil {
+			log.Printf("Failed to sign download URL for %s/%s: %v", path, filename, err)
+		}
+	}
+	var shortID string
+	if s.config.ShortLinks && fileID > 0 {
+		shortID = encodeShortID(fileID)
+	}
+	// 视频封面在后台截取，不延迟上传响应
+	if s.ffmpegPath != "" && filePath != "" && fileID > 0 && strings.HasPrefix(mimeType, "video/") {
+		go s.generatePoster(fileID, filePath)
+	}
+	return &storedFile{
+		path:            path,
+		filename:        filename,
+		encodedFilename: encodedFilename,
+		size:            fileSize,
+		mimeType:        mimeType,
+		checksums:       sums,
+		description:     meta.description,
+		accessCode:      accessCode,
+		shortID:         shortID,
+		signedToken:     signedToken,
+		expiresAt:       expiresAt,
+		protected:       meta.passwordHash != "",
+	}, nil
 }
 
-func (s *FileServer) cleanupExpiredFiles() error {
-	rows, err := s.db.Query(`
-       SELECT path, encoded_filename, filename 
-       FROM files 
-       WHERE upload_time < datetime('now', '-3 days')
-   `)
-	if err != nil {
-		return fmt.Errorf("failed to query expired files: %v", err)
+// respondError 把带状态码的 *fiber.Error 转换为响应，其他错误视为数据库不可用
+func respondError(c *fiber.Ctx, err error) error {
+	if e, ok := err.(*fiber.Error); ok {
+		return c.Status(e.Code).SendString(e.Message)
 	}
-	defer rows.Close()
+	return dbUnavailable(c, err)
+}
 
-	for rows.Next() {
-		var path, encodedFilename, filename string
-		if err := rows.Scan(&path, &encodedFilename, &filename); err != nil {
-			log.Printf("Failed to read file record: %v", err)
+// contentDispositionFilename 从 Content-Disposition 中取出文件名，能够解码的 filename* 优先于 filename。
+// 先逐个参数宽松解析 filename* (支持 UTF-8 与 ISO-8859-1)：mime.ParseMediaType 会丢弃其他字符集的 filename*
+// 而返回 filename，不能先用它的结果；其余情况使用 mime.ParseMediaType 解析引号与转义，
+// 解析失败时使用宽松解析得到的 filename，支持未加引号、直接包含非 ASCII 字符的文件名
+func contentDispositionFilename(cd string) string {
+	var plain, extended string
+	for _, part := range strings.Split(cd, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
 			continue
 		}
-
-		filePath := filepath.Join(s.uploadDir, path, filename)
-		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
-			log.Printf("Failed to delete file %s: %v", filePath, err)
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "filename":
+			plain = strings.Trim(value, `"`)
+		case "filename*":
+			extended = decodeExtendedValue(value)
 		}
-
-		dirPath := filepath.Join(s.uploadDir, path)
-		os.Remove(dirPath)
 	}
-
-	_, err = s.db.Exec(`DELETE FROM files WHERE upload_time < datetime('now', '-3 days')`)
-	if err != nil {
-		return fmt.Errorf("failed to delete expired records: %v", err)
+	if extended != "" {
+		return extended
 	}
-
-	return nil
+	if _, params, err := mime.ParseMediaType(cd); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+	return plain
 }
 
-func sanitizeFilename(filename string) string {
-	if filename == "" {
+// decodeExtendedValue 解码 RFC 5987 形式的参数值 charset'lang'percent-encoded
+func decodeExtendedValue(value string) string {
+	parts := strings.SplitN(value, "'", 3)
+	if len(parts) != 3 {
 		return ""
 	}
-	
-	// 使用 filepath.Base 移除任何路径组件，防止路径遍历
-	filename = filepath.Base(filename)
-	
-	// 移除危险的字符序列
-	filename = strings.ReplaceAll(filename, "..", "")
-	filename = strings.ReplaceAll(filename, "~", "")
-	
-	// 移除控制字符和不可见字符
-	var sanitized strings.Builder
-	for _, r := range filename {
-		if r < 32 || r == 127 {
-			continue // 跳过控制字符
-		}
-		if r == '/' || r == '\\' || r == ':' || r == '*' || r == '?' || r == '"' || r == '<' || r == '>' || r == '|' {
-			continue // 跳过文件系统不安全字符
+	decoded, err := url.PathUnescape(parts[2])
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(parts[0]) {
+	case "utf-8":
+		return decoded
+	case "iso-8859-1":
+		runes := make([]rune, len(decoded))
+		for i := 0; i < len(decoded); i++ {
+			runes[i] = rune(decoded[i])
 		}
-		sanitized.WriteRune(r)
+		return string(runes)
 	}
-	
+	return ""
+}
+
+// normalizePath 合并重复的斜杠并去掉末尾斜杠，使 //abcd//file.txt/ 这类地址也能匹配路由
+func normalizePath(c *fiber.Ctx) error {
+	p := c.Path()
+	cleaned := strings.TrimRight(p, "/")
+	for strings.Contains(cleaned, "//") {
+		cleaned = strings.ReplaceAll(cleaned, "//", "/")
+	}
+	if cleaned == "" {
+		cleaned = "/"
+	}
+	if cleaned != p {
+		c.Path(cleaned)
+	}
+	return c.Next()
+}
+
+// baseURL 返回当前请求的协议与主机，用于拼接返回给客户端的完整地址
+func baseURL(c *fiber.Ctx) string {
+	return c.Protocol() + "://" + c.Hostname()
+}
+
+// uploadFilename 按优先级确定单个上传文件的文件名：X-Filename 头 (可百分号编码) 始终优先，
+// 其后默认依次为 URL 路径、Content-Disposition、?filename= 查询参数与表单中的文件名；
+// FILENAME_PRECEDENCE=header 时 Content-Disposition 优先于 URL 路径
+func (s *FileServer) uploadFilename(c *fiber.Ctx, pathFilename string, formFiles []*multipart.FileHeader) string {
+	override := c.Get("X-Filename")
+	if decoded, err := url.PathUnescape(override); err == nil {
+		override = decoded
+	}
+	var headerFilename string
+	if cd := c.Get(fiber.HeaderContentDisposition); cd != "" {
+		headerFilename = contentDispositionFilename(cd)
+	}
+
+	candidates := []string{override, pathFilename, headerFilename}
+	if s.config.FilenamePrecedence == "header" {
+		candidates = []string{override, headerFilename, pathFilename}
+	}
+	// 部分客户端只能控制查询参数，c.Query 已完成解码
+	candidates = append(candidates, c.Query("filename"))
+	if len(formFiles) == 1 {
+		candidates = append(candidates, formFiles[0].Filename)
+	}
+
+	if pathFilename != "" && headerFilename != "" && pathFilename != headerFilename && override == "" {
+		log.Printf("Upload filename conflict: URL %q, Content-Disposition %q, using %s",
+			pathFilename, headerFilename, s.config.FilenamePrecedence)
+	}
+	for _, name := range candidates {
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// uploadDescription 读取 X-Description 头中的文件说明 (非 ASCII 字符需百分号编码)，
+// 控制字符替换为空格，超出 MAX_DESCRIPTION_LENGTH 个字符时返回错误
+func (s *FileServer) uploadDescription(c *fiber.Ctx) (string, error) {
+	description := c.Get("X-Description")
+	if decoded, err := url.PathUnescape(description); err == nil {
+		description = decoded
+	}
+	if !utf8.ValidString(description) {
+		return "", fmt.Errorf("Invalid description encoding")
+	}
+	description = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, description))
+	if max := s.config.MaxDescriptionLength; utf8.RuneCountInString(description) > max {
+		return "", fmt.Errorf("Description too long, maximum is %d characters", max)
+	}
+	return description, nil
+}
+
+// uploadExpiry 读取 X-Expire 头或 ?expire= 参数指定的保留时长，如 "30m"、"2h"、"7d"，
+// 未指定时为 DEFAULT_EXPIRY；不是正数或超过 MAX_EXPIRY 时返回错误
+func (s *FileServer) uploadExpiry(c *fiber.Ctx) (time.Duration, error) {
+	v := strings.TrimSpace(c.Get("X-Expire"))
+	if v == "" {
+		v = strings.TrimSpace(c.Query("expire"))
+	}
+	if v == "" {
+		return s.config.DefaultExpiry, nil
+	}
+	// 过期时间按秒计算，不足一秒视为无效
+	d, err := parseExpiry(v)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("Invalid expiry %q, expected a positive duration such as 30m, 2h or 7d", v)
+	}
+	if max := s.config.MaxExpiry; d > max {
+		return 0, fmt.Errorf("Expiry too long, maximum is %d seconds", int64(max.Seconds()))
+	}
+	return d, nil
+}
+
+// handleUploadOptions 响应 HEAD 请求，在上传前告知客户端可用的方法与限制
+func (s *FileServer) handleUploadOptions(c *fiber.Ctx) error {
+	c.Set("Allow", "PUT, POST, HEAD")
+	// 上传不支持分段续传
+	c.Set("Accept-Ranges", "none")
+	c.Set("X-Max-File-Size", strconv.FormatInt(s.config.MaxFileSize, 10))
+	// 开启 REQUIRE_UPLOAD_TOKEN 时上传需携带 X-Upload-Token，删除码可选地通过 X-Delete-Code 自定义
+	if s.config.RequireUploadToken {
+		c.Set("X-Upload-Auth", "token")
+	} else {
+		c.Set("X-Upload-Auth", "none")
+	}
+	if s.config.UploadChallenge != "none" {
+		c.Set("X-Upload-Challenge", s.config.UploadChallenge)
+	}
+	return c.SendStatus(200)
+}
+
+func (s *FileServer) handleDownload(c *fiber.Ctx) error {
+	if !s.refererAllowed(c) {
+		return c.Status(403).SendString("Hotlinking not allowed")
+	}
+
+	path := c.Params("path")
+	requestFilename := c.Params("filename")
+
+	decodedRequestFilename, err := url.QueryUnescape(requestFilename)
+	if err != nil {
+		return s.fileNotFound(c)
+	}
+
+	// 拒绝包含路径的文件名以防止路径遍历攻击
+	decodedRequestFilename, ok := safeRequestFilename(decodedRequestFilename)
+	if !ok {
+		return c.Status(400).SendString("Invalid filename")
+	}
+
+	encodedRequestFilename := url.QueryEscape(decodedRequestFilename)
+	if s.notFound.Missing(path, encodedRequestFilename) {
+		return s.fileNotFound(c)
+	}
+	generation := s.notFound.Generation()
+
+	const query = `
+       SELECT filename, file_size, COALESCE(mime_type, ''), checksum_md5, checksum_sha1, checksum_sha256,
+              COALESCE(storage_dir, path), upload_time, expires_at, content IS NOT NULL,
+              compressed, COALESCE(stored_size, file_size), COALESCE(description, ''),
+              private, delete_code, COALESCE(access_code, ''), COALESCE(password_hash, '')
+       FROM files WHERE path = ? AND encoded_filename = ?
+   `
+	var originalFilename, mimeType, storageDir, description string
+	var private bool
+	var deleteCode, accessCode, passwordHash string
+	var fileSize, storedSize int64
+	var uploadTime time.Time
+	var expiresAt sql.NullTime
+	var md5Sum, sha1Sum, sha256Sum sql.NullString
+	var inDB, compressed bool
+	err = s.db.QueryRow(query, path, encodedRequestFilename).Scan(&originalFilename, &fileSize, &mimeType, &md5Sum, &sha1Sum, &sha256Sum, &storageDir, &uploadTime, &expiresAt, &inDB, &compressed, &storedSize, &description, &private, &deleteCode, &accessCode, &passwordHash)
+	if err == sql.ErrNoRows && s.config.CaseInsensitiveDownload {
+		// 部分客户端会改变文件名大小写，精确匹配失败时在同一路径下忽略大小写查找
+		matches, lookupErr := s.findFilenameIgnoreCase(path, decodedRequestFilename)
+		if lookupErr != nil {
+			return dbUnavailable(c, lookupErr)
+		}
+		switch len(matches) {
+		case 0:
+		case 1:
+			encodedRequestFilename = matches[0]
+			err = s.db.QueryRow(query, path, encodedRequestFilename).Scan(&originalFilename, &fileSize, &mimeType, &md5Sum, &sha1Sum, &sha256Sum, &storageDir, &uploadTime, &expiresAt, &inDB, &compressed, &storedSize, &description, &private, &deleteCode, &accessCode, &passwordHash)
+		default:
+			return multipleChoices(c, path, matches)
+		}
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			s.notFound.Add(generation, path, url.QueryEscape(decodedRequestFilename))
+			return s.fileNotFound(c)
+		}
+		return dbUnavailable(c, err)
+	}
+	// 过期到清理之间不再提供下载，SLIDING_EXPIRY 也不会因下载而使其复活
+	if isExpired(expiresAt) {
+		return s.fileExpired(c)
+	}
+
+	// 私有文件需提供访问码或删除码，落地页中的链接沿用请求中的凭据
+	var privateCode string
+	if private {
+		code, ok := privateFileCode(c, deleteCode, accessCode)
+		if !ok {
+			return c.Status(403).SendString("This file is private, an access code is required")
+		}
+		privateCode = code
+		c.Set("Cache-Control", "private, no-store")
+	}
+	// 设有下载密码时落地页与下载都需要密码，浏览器在同一站点内自动重用登录框中输入的密码
+	if err := s.requireDownloadPassword(c, path, encodedRequestFilename, passwordHash, deleteCode); err != nil {
+		return respondError(c, err)
+	}
+	if passwordHash != "" {
+		c.Set("Cache-Control", "private, no-store")
+	}
+
+	filePath := filepath.Join(s.uploadDir, storageDir, originalFilename)
+	if !inDB {
+		err := s.checkWithinUploadDir(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Refusing to serve %s/%s: %v", path, originalFilename, err)
+			return s.fileNotFound(c)
+		}
+		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+			return s.danglingRecord(c, path, storageDir, originalFilename, encodedRequestFilename, storedSize, uploadTime)
+		}
+	}
+
+	// 浏览器先看到包含文件信息的落地页，?raw=1 或命令行工具直接获取文件内容
+	if raw, _ := strconv.ParseBool(c.Query("raw")); !raw && isBrowser(c) {
+		setNoIndex(c)
+		// 同一地址对命令行工具直接返回文件内容，缓存只能按请求头区分
+		c.Vary(fiber.HeaderAccept, fiber.HeaderUserAgent)
+		if !private && passwordHash == "" {
+			c.Set("Cache-Control", landingPageCacheControl(s.config.LandingPageMaxAge, expiresAt))
+		}
+		// 图片通过预览接口显示缩略图，已生成封面的视频显示封面，均不计入下载次数
+		previewURL := ""
+		if strings.HasPrefix(mimeType, "image/") {
+			previewURL = fmt.Sprintf("/preview/%s/%s", path, encodedRequestFilename)
+		} else if strings.HasPrefix(mimeType, "video/") && s.hasPoster(path, encodedRequestFilename) {
+			previewURL = fmt.Sprintf("/thumbnail/%s/%s", path, encodedRequestFilename)
+		}
+		downloadURL := fmt.Sprintf("/%s/%s?raw=1", path, encodedRequestFilename)
+		if privateCode != "" {
+			downloadURL += "&code=" + url.QueryEscape(privateCode)
+			if previewURL != "" {
+				previewURL += "?code=" + url.QueryEscape(privateCode)
+			}
+		}
+		return c.Render("static/file.html", fiber.Map{
+			"ServerHost":  c.Hostname(),
+			"Filename":    originalFilename,
+			"Description": description,
+			"Size":        formatFileSize(fileSize),
+			"MimeType":    mimeType,
+			"UploadTime":  uploadTime.Local().Format(timeLayout),
+			"ExpireTime":  expireTimeText(expiresAt),
+			"DownloadURL": downloadURL,
+			"PreviewURL":  previewURL,
+		})
+	}
+
+	downloadKey := path + "/" + encodedRequestFilename
+	if !s.downloads.Acquire(downloadKey, s.config.MaxDownloadsPerFile) {
+		c.Set("Retry-After", "10")
+		return c.Status(429).SendString("Too many concurrent downloads of this file")
+	}
+
+	// 回调在处理函数返回后执行，需提前取出请求信息
+	head := c.Method() == fiber.MethodHead
+	probe := isRangeProbe(c.Get(fiber.HeaderRange), fileSize)
+
+	setNoIndex(c)
+	c.Set(fiber.HeaderContentDisposition,
+		s.contentDisposition(c, responseContentType(originalFilename, mimeType), originalFilename))
+	if md5Sum.Valid {
+		c.Set("Content-MD5", contentMD5(md5Sum.String))
+	}
+	if digest := digestHeader(map[string]string{
+		"md5":    md5Sum.String,
+		"sha1":   sha1Sum.String,
+		"sha256": sha256Sum.String,
+	}); digest != "" {
+		c.Set("Digest", digest)
+	}
+
+	onDone := func(sent int64) {
+		s.downloads.Release(downloadKey)
+		if countsAsDownload(s.config.DownloadCountMode, head, probe, sent, fileSize) {
+			s.incrementDownloadCount(path, encodedRequestFilename)
+		}
+	}
+	if inDB {
+		var content []byte
+		if err := s.db.QueryRow("SELECT content FROM files WHERE path = ? AND encoded_filename = ?",
+			path, encodedRequestFilename).Scan(&content); err != nil {
+			onDone(0)
+			if err == sql.ErrNoRows {
+				return c.Status(404).SendString("File not found")
+			}
+			return dbUnavailable(c, err)
+		}
+		if err := checkStoredSize(storedSize, int64(len(content))); err != nil {
+			onDone(0)
+			return storageDrift(c, path, originalFilename, err)
+		}
+		if compressed {
+			return serveCompressed(c, bytes.NewReader(content), nil, int64(len(content)), fileSize,
+				uploadTime, originalFilename, mimeType, onDone)
+		}
+		return serveBlob(c, content, originalFilename, mimeType, uploadTime, onDone)
+	}
+	// Content-Length 按记录的大小给出，与磁盘上的字节数核对
+	onDone = s.trackReader(filePath, onDone)
+	if compressed {
+		err = serveCompressedFile(c, filePath, storedSize, fileSize, mimeType, onDone)
+	} else {
+		err = serveFile(c, filePath, fileSize, mimeType, onDone)
+	}
+	if errors.Is(err, errSizeMismatch) {
+		return storageDrift(c, path, originalFilename, err)
+	}
+	if err != nil {
+		return c.Status(404).SendString("File not found")
+	}
+	return nil
+}
+
+// storageDrift 存储的字节数与记录不一致时记录日志并返回 500：按记录的 Content-Length 发送会得到截断或多余的内容，
+// 按实际字节数发送又与记录的大小和校验值不符，两者都不可靠，不发送
+func storageDrift(c *fiber.Ctx, path, filename string, err error) error {
+	log.Printf("Storage drift for %s/%s: %v", path, filename, err)
+	return c.Status(500).SendString("Stored file does not match its record")
+}
+
+// landingPageCacheControl 文件信息页的缓存策略：页面显示过期时间，缓存时长不超过文件剩余的保留时间，
+// 避免文件过期后浏览器仍显示可下载；只允许浏览器缓存，私有文件的页面不缓存
+func landingPageCacheControl(maxAge time.Duration, expiresAt sql.NullTime) string {
+	if expiresAt.Valid {
+		if remaining := time.Until(expiresAt.Time); remaining < maxAge {
+			maxAge = remaining
+		}
+	}
+	if maxAge < time.Second {
+		return "no-cache"
+	}
+	return fmt.Sprintf("private, max-age=%d", int64(maxAge.Seconds()))
+}
+
+// fileNotFound 文件不存在时，浏览器看到说明文件可能已过期或被删除的页面，
+// 其他客户端仍得到纯文本；页面为 static/404.html，可直接替换定制
+func (s *FileServer) fileNotFound(c *fiber.Ctx) error {
+	return s.missingFile(c, 404, "File not found")
+}
+
+// isExpired 判断记录是否已过期；过期清理定时运行，过期后到被清理前记录仍在数据库中
+func isExpired(expiresAt sql.NullTime) bool {
+	return expiresAt.Valid && time.Now().After(expiresAt.Time)
+}
+
+// fileExpired 已过期但尚未被清理的文件返回 410，与短链接一致
+func (s *FileServer) fileExpired(c *fiber.Ctx) error {
+	return s.missingFile(c, 410, "File has expired or been deleted")
+}
+
+// 刚写入记录、尚未移动到最终位置的上传在这段时间内不视为缺失，避免误删记录
+const danglingRecordGrace = time.Minute
+
+// danglingRecord 记录存在而磁盘文件缺失 (被外部删除、存储卷未挂载等) 时按存储不一致记录日志并返回 410，
+// 告知客户端文件曾经存在但已不可用；开启 REMOVE_DANGLING_RECORDS 时同时删除该记录并归还配额
+func (s *FileServer) danglingRecord(c *fiber.Ctx, path, storageDir, filename, encodedFilename string, storedSize int64, uploadTime time.Time) error {
+	log.Printf("Storage inconsistency: %s/%s has a database record but %s is missing on disk",
+		path, filename, filepath.Join(s.uploadDir, storageDir, filename))
+	if s.config.RemoveDanglingRecords && time.Since(uploadTime) > danglingRecordGrace {
+		result, err := s.execWithRetry("DELETE FROM files WHERE path = ? AND encoded_filename = ? AND content IS NULL",
+			path, encodedFilename)
+		if err != nil {
+			log.Printf("Failed to remove dangling record %s/%s: %v", path, filename, err)
+		} else if n, _ := result.RowsAffected(); n > 0 {
+			s.quota.Free(storedSize)
+			s.removeDirIfEmpty(path, storageDir)
+			log.Printf("Removed dangling record %s/%s", path, filename)
+		}
+	}
+	return s.missingFile(c, 410, "File is no longer available")
+}
+
+// missingFile 以指定状态码返回文件不存在的响应，浏览器看到 static/404.html
+func (s *FileServer) missingFile(c *fiber.Ctx, status int, message string) error {
+	c.Status(status)
+	if !isBrowser(c) {
+		return c.SendString(message)
+	}
+	setNoIndex(c)
+	return c.Render("static/404.html", fiber.Map{
+		"ServerHost": c.Hostname(),
+		"Retention":  formatExpiry(s.config.DefaultExpiry),
+	})
+}
+
+// refererAllowed 防盗链：配置了 REFERER_ALLOWLIST 时，只允许本站及名单内站点引用下载链接。
+// 名单项以 "." 开头时匹配该域名下的所有子域名
+func (s *FileServer) refererAllowed(c *fiber.Ctx) bool {
+	if len(s.config.RefererAllowlist) == 0 {
+		return true
+	}
+	referer := c.Get(fiber.HeaderReferer)
+	if referer == "" {
+		return s.config.RefererAllowEmpty
+	}
+	u, err := url.Parse(referer)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, c.Hostname()) {
+		return true
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range s.config.RefererAllowlist {
+		if host == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// findFilenameIgnoreCase 返回路径下与文件名忽略大小写相同的所有 encoded_filename
+func (s *FileServer) findFilenameIgnoreCase(path, filename string) ([]string, error) {
+	rows, err := s.db.Query("SELECT filename, encoded_filename FROM files WHERE path = ?", path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []string
+	for rows.Next() {
+		var name, encoded string
+		if err := rows.Scan(&name, &encoded); err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(name, filename) {
+			matches = append(matches, encoded)
+		}
+	}
+	return matches, rows.Err()
+}
+
+// multipleChoices 忽略大小写匹配到多个文件时返回 300，列出所有候选地址
+func multipleChoices(c *fiber.Ctx, path string, encodedFilenames []string) error {
+	urls := make([]string, len(encodedFilenames))
+	for i, encoded := range encodedFilenames {
+		urls[i] = fmt.Sprintf("/%s/%s", path, encoded)
+	}
+	c.Status(300)
+	if isTextPreferred(c) {
+		return c.Type("text").SendString("Multiple files match:\n" + strings.Join(urls, "\n") + "\n")
+	}
+	return sendJSON(c, fiber.Map{"choices": urls})
+}
+
+func (s *FileServer) handleDelete(c *fiber.Ctx) error {
+	path := c.Params("path")
+	requestFilename := c.Params("filename")
+
+	decodedFilename, err := url.QueryUnescape(requestFilename)
+	if err != nil {
+		return c.Status(404).SendString("File not found")
+	}
+
+	// 拒绝包含路径的文件名以防止路径遍历攻击
+	decodedFilename, ok := safeRequestFilename(decodedFilename)
+	if !ok {
+		return c.Status(400).SendString("Invalid filename")
+	}
+
+	encodedFilename := url.QueryEscape(decodedFilename)
+
+	decodedDeleteCode, err := deleteCodeFromRequest(c)
+	if err != nil {
+		return c.Status(400).SendString("Invalid delete code")
+	}
+	// 管理员签发的附加删除令牌同样可以删除文件
+	decodedDeleteCode, deleteToken, err := s.resolveDeleteCode(path, encodedFilename, decodedDeleteCode)
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	// 开启确认流程后，浏览器等非命令行客户端需先 GET 获取确认令牌
+	requireConfirm := s.config.DeleteConfirmation && !isTextPreferred(c)
+	if err := s.deleteFile(path, encodedFilename, decodedDeleteCode, c.Get("X-Confirm-Token"), requireConfirm); err != nil {
+		return respondError(c, err)
+	}
+	if deleteToken != nil {
+		log.Printf("Deleted %s/%s with delete token %d (%q) from %s",
+			path, decodedFilename, deleteToken.id, deleteToken.label, clientIP(c))
+	}
+
+	return c.Status(200).SendString("OK")
+}
+
+// deleteFile 凭删除码删除文件与记录；返回的 *fiber.Error 带有应答给客户端的状态码。
+// 开启 IDEMPOTENT_DELETE 时文件已不存在 (如重试已成功的删除) 视为成功，删除码错误仍返回 403
+func (s *FileServer) deleteFile(path, encodedFilename, deleteCode, confirmToken string, requireConfirm bool) error {
+	var filename, storageDir string
+	var storedSize int64
+	err := s.db.QueryRow(
+		"SELECT filename, COALESCE(stored_size, file_size), COALESCE(storage_dir, path) FROM files WHERE path = ? AND encoded_filename = ? AND delete_code = ?",
+		path, encodedFilename, deleteCode,
+	).Scan(&filename, &storedSize, &storageDir)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return err
+		}
+		if s.config.IdempotentDelete {
+			var exists int
+			if err := s.db.QueryRow("SELECT COUNT(*) FROM files WHERE path = ? AND encoded_filename = ?",
+				path, encodedFilename).Scan(&exists); err != nil {
+				return err
+			}
+			if exists == 0 {
+				log.Printf("Delete of %s/%s: already deleted", path, encodedFilename)
+				return nil
+			}
+		}
+		return fiber.NewError(403, "Invalid delete code")
+	}
+
+	if requireConfirm && !s.verifyConfirmToken(confirmToken, path, encodedFilename, deleteCode) {
+		return fiber.NewError(403, "Invalid or expired confirmation token")
+	}
+
+	_, err = s.execWithRetry(
+		"DELETE FROM files WHERE path = ? AND encoded_filename = ? AND delete_code = ?",
+		path, encodedFilename, deleteCode,
+	)
+	if err != nil {
+		return err
+	}
+	s.quota.Free(storedSize)
+
+	// 记录已删除，新的下载找不到该文件；正在进行的下载结束后才从磁盘移除
+	filePath := filepath.Join(s.uploadDir, storageDir, filename)
+	if !withinDir(s.uploadDir, filePath) {
+		log.Printf("Refusing to delete %s: outside the uploads directory", filePath)
+	} else if err := s.removeStoredFile(path, storageDir, filePath); err != nil {
+		log.Printf("Error deleting file: %v", err)
+	}
+	return nil
+}
+
+// handleOwnerInfo 凭删除码返回文件的完整元数据 (不返回文件内容)
+func (s *FileServer) handleOwnerInfo(c *fiber.Ctx) error {
+	path := c.Params("path")
+	decodedFilename, ok := decodeRequestFilename(c.Params("filename"))
+	if !ok {
+		return c.Status(404).SendString("File not found")
+	}
+	encodedFilename := url.QueryEscape(decodedFilename)
+
+	deleteCode, err := deleteCodeFromRequest(c)
+	if err != nil || deleteCode == "" {
+		return c.Status(401).SendString("Delete code required")
+	}
+
+	var (
+		filename, mimeType         string
+		description, accessCode    string
+		private                    bool
+		fileSize, downloadCount    int64
+		uploadTime                 time.Time
+		expiresAt                  sql.NullTime
+		md5Sum, sha1Sum, sha256Sum sql.NullString
+	)
+	err = s.db.QueryRow(`
+       SELECT filename, file_size, COALESCE(mime_type, ''), upload_time, expires_at, download_count,
+              checksum_md5, checksum_sha1, checksum_sha256, COALESCE(description, ''),
+              private, COALESCE(access_code, '')
+       FROM files WHERE path = ? AND encoded_filename = ? AND delete_code = ?
+   `, path, encodedFilename, deleteCode).Scan(&filename, &fileSize, &mimeType, &uploadTime, &expiresAt,
+		&downloadCount, &md5Sum, &sha1Sum, &sha256Sum, &description, &private, &accessCode)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(403).SendString("Invalid delete code")
+		}
+		return dbUnavailable(c, err)
+	}
+
+	checksums := fiber.Map{}
+	for alg, sum := range map[string]sql.NullString{"md5": md5Sum, "sha1": sha1Sum, "sha256": sha256Sum} {
+		if sum.Valid {
+			checksums[alg] = sum.String
+		}
+	}
+
+	c.Set("Cache-Control", "no-store")
+	setNoIndex(c)
+	info := fiber.Map{
+		"path":          path,
+		"filename":      filename,
+		"deleteCode":    deleteCode,
+		"size":          fileSize,
+		"mimeType":      mimeType,
+		"uploadTime":    uploadTime.Local().Format(timeLayout),
+		"downloadCount": downloadCount,
+		"checksums":     checksums,
+	}
+	if description != "" {
+		info["description"] = description
+	}
+	if private {
+		info["private"] = true
+		info["accessCode"] = accessCode
+	}
+	setExpiry(info, expiresAt)
+	return sendJSON(c, info)
+}
+
+// decodeRequestFilename 解码并检查 URL 中的文件名，见 safeRequestFilename
+func decodeRequestFilename(raw string) (string, bool) {
+	decoded, err := url.QueryUnescape(raw)
+	if err != nil {
+		return "", false
+	}
+	return safeRequestFilename(decoded)
+}
+
+func sanitizeFilename(filename string) string {
+	if !hasVisibleRune(filename) {
+		return ""
+	}
+	if result := cleanFilename(filename); result != "" {
+		return result
+	}
+	return "unnamed_file"
+}
+
+// 目录分隔符及 Unicode 中形似分隔符的字符
+const filenameSeparators = "/\\\u2044\u2215\u29f8\ufe68\uff0f\uff3c"
+
+// safeRequestFilename 检查并清理客户端在 URL 或 Content-Disposition 中给出的文件名。
+// 含有目录分隔符 (包括形似分隔符的多字节字符与解码后仍残留的 %2f、%5c)、为 "." 或 ".."，
+// 或清理后为空的文件名直接拒绝，而不是改写成出乎意料的名称
+func safeRequestFilename(name string) (string, bool) {
+	if !hasVisibleRune(name) || strings.ContainsAny(name, filenameSeparators) {
+		return "", false
+	}
+	if trimmed := strings.TrimSpace(name); trimmed == "." || trimmed == ".." {
+		return "", false
+	}
+	if lower := strings.ToLower(name); strings.Contains(lower, "%2f") || strings.Contains(lower, "%5c") {
+		return "", false
+	}
+	clean := cleanFilename(name)
+	return clean, clean != ""
+}
+
+// cleanFilename 去除文件名中的路径与不安全字符，结果为空、"." 或 ".." 时返回空字符串
+func cleanFilename(filename string) string {
+	// 使用 filepath.Base 移除任何路径组件，防止路径遍历
+	filename = filepath.Base(filename)
+
+	// 移除危险的字符序列
+	filename = strings.ReplaceAll(filename, "..", "")
+	filename = strings.ReplaceAll(filename, "~", "")
+
+	// 移除控制字符和不可见字符
+	var sanitized strings.Builder
+	for _, r := range filename {
+		if r < 32 || r == 127 {
+			continue // 跳过控制字符
+		}
+		if r == '/' || r == '\\' || r == ':' || r == '*' || r == '?' || r == '"' || r == '<' || r == '>' || r == '|' {
+			continue // 跳过文件系统不安全字符
+		}
+		sanitized.WriteRune(r)
+	}
+
 	result := strings.TrimSpace(sanitized.String())
-	
+
 	// 确保文件名不为空且不是特殊名称
 	if result == "" || result == "." || result == ".." {
-		return "unnamed_file"
+		return ""
 	}
-	
+
 	// 限制文件名长度
 	if len(result) > 255 {
 		ext := filepath.Ext(result)
 		base := result[:255-len(ext)]
 		result = base + ext
 	}
-	
+
 	return result
 }
 
+// 同名冲突时最多尝试的编号后缀数
+const maxNameSuffix = 1000
+
+// suffixedFilename 按 NAME_CONFLICT_SUFFIX 模板在扩展名前插入编号，{n} 替换为 n，
+// 如 "-{n}" 得到 report-1.pdf，" ({n})" 得到 report (1).pdf；超过 255 字节时截短主文件名
+func suffixedFilename(filename, template string, n int) string {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	suffix := strings.ReplaceAll(template, "{n}", strconv.Itoa(n))
+	if over := len(base) + len(suffix) + len(ext) - 255; over > 0 {
+		base = strings.ToValidUTF8(base[:max(len(base)-over, 0)], "")
+	}
+	return base + suffix + ext
+}
+
+// hasVisibleRune 判断文件名中是否含有空白、控制与格式字符以外的有效字符，
+// 例如 "%20%20" 解码后只剩空格，不能作为文件名
+func hasVisibleRune(name string) bool {
+	for _, r := range name {
+		if !unicode.IsSpace(r) && !unicode.IsControl(r) && !unicode.Is(unicode.Cf, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// storageDirFor 按存储布局计算路径在 uploadDir 下的目录，
+// sharded 布局为 ab/cd/abcd，避免单层目录下文件过多；date 布局按上传日期 (本地时间) 分为 2024/01/15/abcd，
+// 便于按日期浏览与手动清理。访问地址中的路径不变，实际目录记录在 storage_dir 中
+func (s *FileServer) storageDirFor(path string) string {
+	switch {
+	case s.config.StorageLayout == "sharded" && len(path) >= 4:
+		return filepath.Join(path[0:2], path[2:4], path)
+	case s.config.StorageLayout == "date":
+		return filepath.Join(time.Now().Format("2006/01/02"), path)
+	}
+	return path
+}
+
+// 外部提供的路径（导入记录、磁盘恢复）允许的最大目录层级与长度，
+// date 布局最深为 4 层
+const (
+	maxPathDepth  = 4
+	maxPathLength = 255
+)
+
+// validateStoragePath 校验外部提供的路径与存储目录：路径必须是单个目录名，
+// 存储目录必须是 uploadDir 内的相对路径，且层级和长度不超过上限，同时防止目录穿越与深层嵌套
+func validateStoragePath(path, storageDir string) error {
+	if path == "" || path == "." || path == ".." || strings.ContainsAny(path, "/\\\x00") {
+		return fmt.Errorf("invalid path %q", path)
+	}
+	if storageDir == "" {
+		storageDir = path
+	}
+	if len(storageDir) > maxPathLength {
+		return fmt.Errorf("storage dir exceeds %d bytes", maxPathLength)
+	}
+	if filepath.IsAbs(storageDir) || strings.ContainsAny(storageDir, "\\\x00") {
+		return fmt.Errorf("invalid storage dir %q", storageDir)
+	}
+	parts := strings.Split(storageDir, "/")
+	if len(parts) > maxPathDepth {
+		return fmt.Errorf("storage dir exceeds %d levels", maxPathDepth)
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid storage dir %q", storageDir)
+		}
+	}
+	if parts[len(parts)-1] != path {
+		return fmt.Errorf("storage dir %q does not end with path %q", storageDir, path)
+	}
+	return nil
+}
+
+// isDirNotEmpty 判断删除目录失败是否因为目录不为空，POSIX 允许此时返回 ENOTEMPTY 或 EEXIST
+func isDirNotEmpty(err error) bool {
+	return errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST)
+}
+
+// removeDirIfEmpty 持有路径锁并确认目录为空后才删除，避免误删并发上传正在使用的目录；
+// 分片与日期布局下逐级向上删除空的上级目录
+func (s *FileServer) removeDirIfEmpty(path, storageDir string) {
+	unlock := s.pathLocks.Lock(path)
+	defer unlock()
+
+	for dir := storageDir; dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		dirPath := filepath.Join(s.uploadDir, dir)
+		entries, err := os.ReadDir(dirPath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("Failed to read directory %s: %v", dirPath, err)
+			}
+			return
+		}
+		if len(entries) > 0 {
+			return
+		}
+		if err := os.Remove(dirPath); err != nil && !os.IsNotExist(err) {
+			// 共用的上级目录 (STORAGE_LAYOUT 为 sharded 或 date) 或其他进程可能刚在其中写入文件，
+			// 目录已不为空属于正常情况，不记录日志；只有真正的异常 (如权限错误) 才记录警告
+			if !isDirNotEmpty(err) {
+				log.Printf("Warning: failed to remove empty directory %s: %v", dirPath, err)
+			}
+			return
+		}
+	}
+}
+
+// 上传过程中临时文件的名称前缀，清理与恢复时据此跳过
+const tempFilePrefix = ".upload-"
+
+// withinDir 按清理后的路径判断 p 是否位于 root 之内，不解析符号链接
+func withinDir(root, p string) bool {
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(p))
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) &&
+		!filepath.IsAbs(rel)
+}
+
+// checkWithinUploadDir 路径不在上传目录内时返回错误；开启 CONFINE_SYMLINKS 时还会解析路径中的符号链接，
+// 实际位置不在上传目录内 (或无法解析) 时返回错误，防止通过符号链接读取存储之外的文件
+func (s *FileServer) checkWithinUploadDir(filePath string) error {
+	if !withinDir(s.uploadDir, filePath) {
+		return fmt.Errorf("outside the uploads directory: %s", filePath)
+	}
+	if !s.config.ConfineSymlinks {
+		return nil
+	}
+	root, err := filepath.EvalSymlinks(s.uploadDir)
+	if err != nil {
+		return err
+	}
+	resolved, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("resolves outside the uploads directory: %s", resolved)
+	}
+	return nil
+}
+
+// isTextExtension 判断文件扩展名是否在 TEXT_EXTENSIONS 中
+func (s *FileServer) isTextExtension(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, e := range s.config.TextExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// requestBodyReader 返回请求体的读取流，开启 StreamRequestBody 后大请求体不会整体缓存在内存中
+func requestBodyReader(c *fiber.Ctx) io.Reader {
+	if stream := c.Context().RequestBodyStream(); stream != nil {
+		return stream
+	}
+	return bytes.NewReader(c.Body())
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
 func generateRandomString(length int) string {
 	const chars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	result := make([]byte, length)
@@ -413,29 +1938,266 @@ func generateRandomPath() string {
 	return generateRandomString(4)
 }
 
+// 生成路径时的最大重试次数
+const maxPathAttempts = 10
+
+// reusablePath 校验删除码属于该路径下的某个文件，返回路径实际所在的存储目录
+func (s *FileServer) reusablePath(path, deleteCode string) (string, error) {
+	var storageDir string
+	err := s.db.QueryRow("SELECT COALESCE(storage_dir, path) FROM files WHERE path = ? AND delete_code = ? LIMIT 1",
+		path, deleteCode).Scan(&storageDir)
+	return storageDir, err
+}
+
+// newPath 按配置的风格生成路径，与已有路径冲突时重新生成
+func (s *FileServer) newPath() (string, error) {
+	var path string
+	for i := 0; i < maxPathAttempts; i++ {
+		if s.config.PathStyle == "words" {
+			path = generateWordSlug()
+		} else {
+			path = generateRandomPath()
+		}
+
+		var exists int
+		err := s.db.QueryRow("SELECT COUNT(*) FROM files WHERE path = ?", path).Scan(&exists)
+		if err != nil {
+			return "", err
+		}
+		if exists == 0 {
+			if _, err := os.Stat(filepath.Join(s.uploadDir, s.storageDirFor(path))); os.IsNotExist(err) {
+				return path, nil
+			}
+		}
+	}
+	// 多次冲突后仍返回最后一次生成的路径，由 UNIQUE 约束兜底
+	log.Printf("Path collision after %d attempts: %s", maxPathAttempts, path)
+	return path, nil
+}
+
+// validateDeleteCode 检查客户端自定义删除码的强度
+func (s *FileServer) validateDeleteCode(code string) error {
+	if len(code) < s.config.DeleteCodeMinLength {
+		return fmt.Errorf("Delete code must be at least %d characters", s.config.DeleteCodeMinLength)
+	}
+
+	var lower, upper, digit, symbol int
+	for _, r := range code {
+		switch {
+		case r < 33 || r > 126:
+			return fmt.Errorf("Delete code must contain printable ASCII characters only")
+		case r >= 'a' && r <= 'z':
+			lower = 1
+		case r >= 'A' && r <= 'Z':
+			upper = 1
+		case r >= '0' && r <= '9':
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	if lower+upper+digit+symbol < s.config.DeleteCodeMinClasses {
+		return fmt.Errorf("Delete code must mix at least %d of: lowercase, uppercase, digits, symbols",
+			s.config.DeleteCodeMinClasses)
+	}
+	return nil
+}
+
+// generatedDeleteCodeLength 服务端生成的删除码长度，始终明显高于最小长度要求
+func (s *FileServer) generatedDeleteCodeLength() int {
+	if n := s.config.DeleteCodeMinLength + 4; n > 12 {
+		return n
+	}
+	return 12
+}
+
+// privateFileCode 从请求中取出访问私有文件的凭据 (与删除码相同的 Authorization 头或 ?code=)，
+// 与文件的访问码或删除码一致时返回该凭据
+func privateFileCode(c *fiber.Ctx, deleteCode, accessCode string) (string, bool) {
+	code, err := deleteCodeFromRequest(c)
+	if err != nil || code == "" {
+		return "", false
+	}
+	if subtle.ConstantTimeCompare([]byte(code), []byte(deleteCode)) == 1 ||
+		(accessCode != "" && subtle.ConstantTimeCompare([]byte(code), []byte(accessCode)) == 1) {
+		return code, true
+	}
+	return "", false
+}
+
+// deleteCodeFromRequest 优先从 Authorization 头 (Bearer 或 DeleteCode 方案) 读取删除码，
+// 兼容旧的 ?code= 查询参数，但后者会出现在访问日志和代理中，安全性较低
+func deleteCodeFromRequest(c *fiber.Ctx) (string, error) {
+	if auth := strings.TrimSpace(c.Get("Authorization")); auth != "" {
+		scheme, code, ok := strings.Cut(auth, " ")
+		if ok && (strings.EqualFold(scheme, "Bearer") || strings.EqualFold(scheme, "DeleteCode")) {
+			return strings.TrimSpace(code), nil
+		}
+	}
+	return url.QueryUnescape(c.Query("code"))
+}
+
+// dbUnavailable 数据库暂时不可用 (锁定、损坏等) 时返回 503，提示客户端稍后重试，
+// 与记录确实不存在的 404 区分开
+func dbUnavailable(c *fiber.Ctx, err error) error {
+	log.Printf("Database error: %v", err)
+	c.Set("Retry-After", "5")
+	return c.Status(503).SendString("Service temporarily unavailable")
+}
+
+// sendJSON 输出 JSON 响应，请求带 ?pretty=1 时缩进输出，方便在终端中调试
+func sendJSON(c *fiber.Ctx, v interface{}) error {
+	if pretty, _ := strconv.ParseBool(c.Query("pretty")); !pretty {
+		return c.JSON(v)
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(append(data, '\n'))
+}
+
+// uploadResponseFormat 上传结果的格式：?format= 可指定 json、text、csv 或 html，
+// 未指定时浏览器表单提交返回 html 结果页，curl/wget 返回 text，其余客户端返回 json
+func uploadResponseFormat(c *fiber.Ctx) string {
+	switch format := strings.ToLower(c.Query("format")); format {
+	case "json", "text", "csv", "html":
+		return format
+	}
+	if isBrowser(c) {
+		return "html"
+	}
+	if isTextPreferred(c) {
+		return "text"
+	}
+	return "json"
+}
+
+// uploadCSVHeader 上传结果 CSV 的列，失败的文件只有 filename、status 与 error
+var uploadCSVHeader = []string{"filename", "url", "deleteCode", "size", "status", "error"}
+
+// sendCSV 以 CSV 格式发送上传结果，首行为列名，便于脚本和表格处理
+func sendCSV(c *fiber.Ctx, records [][]string) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(uploadCSVHeader)
+	if err := w.WriteAll(records); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	return c.Send(buf.Bytes())
+}
+
+// setNoIndex 阻止搜索引擎收录上传的文件及文件列表
+func setNoIndex(c *fiber.Ctx) {
+	c.Set("X-Robots-Tag", "noindex, nofollow")
+}
+
+// isBrowser 判断请求是否来自浏览器页面导航 (接受 HTML 且不是命令行工具)
+func isBrowser(c *fiber.Ctx) bool {
+	return !isTextPreferred(c) && strings.Contains(c.Get(fiber.HeaderAccept), "text/html")
+}
+
+func formatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// clientIP 返回客户端 IP。连接来自可信代理时从 PROXY_HEADER 读取：X-Forwarded-For 从右向左跳过可信代理，
+// 取第一个不可信的地址，客户端自行填写在左侧的地址不会被采用；缺少该头或其中没有有效地址时
+// 退回连接的对端地址
+func clientIP(c *fiber.Ctx) string {
+	remote := c.Context().RemoteIP().String()
+	cfg := c.App().Config()
+	if cfg.ProxyHeader == "" || !c.IsProxyTrusted() {
+		return remote
+	}
+	if !strings.EqualFold(cfg.ProxyHeader, fiber.HeaderXForwardedFor) {
+		if ip := net.ParseIP(strings.TrimSpace(c.Get(cfg.ProxyHeader))); ip != nil {
+			return ip.String()
+		}
+		return remote
+	}
+	hops := strings.Split(c.Get(fiber.HeaderXForwardedFor), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		if i == 0 || !isTrustedProxy(ip, cfg.TrustedProxies) {
+			return ip.String()
+		}
+	}
+	return remote
+}
+
+// isTrustedProxy 判断 ip 是否在 TRUSTED_PROXIES 列出的地址或网段内
+func isTrustedProxy(ip net.IP, proxies []string) bool {
+	for _, proxy := range proxies {
+		if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		} else if ip.Equal(net.ParseIP(proxy)) {
+			return true
+		}
+	}
+	return false
+}
+
 func isTextPreferred(c *fiber.Ctx) bool {
 	userAgent := c.Get("User-Agent")
 	return strings.HasPrefix(userAgent, "curl/") || strings.HasPrefix(userAgent, "Wget/")
 }
 
 func main() {
+	recoverMode := flag.Bool("recover", false, "rebuild missing database records from files in the uploads directory before serving")
+	flag.Parse()
+
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 
-	server, err := NewFileServer()
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server, err := NewFileServer(config)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Prefork 时子进程同样执行 main，恢复与过期清理只在主进程中运行一次
+	if *recoverMode && !fiber.IsChild() {
+		n, err := server.recoverFromDisk()
+		if err != nil {
+			log.Fatalf("Recovery failed: %v", err)
+		}
+		log.Printf("Recovery finished, %d files recovered", n)
+	}
+
 	server.setupRoutes()
 
-	go func() {
-		for {
-			if err := server.cleanupExpiredFiles(); err != nil {
-				log.Printf("Cleanup failed: %v", err)
+	if fiber.IsChild() {
+		go server.syncQuotaUsage()
+	} else {
+		go func() {
+			for {
+				if err := server.cleanupExpiredFiles(); err != nil {
+					log.Printf("Cleanup failed: %v", err)
+				}
+				time.Sleep(1 * time.Hour)
 			}
-			time.Sleep(1 * time.Hour)
-		}
-	}()
+		}()
+	}
 
 	log.Printf("Server starting on :8080")
 	log.Fatal(server.app.Listen(":8080"))
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// newTestDB 在临时目录中创建与 NewFileServer 相同结构的数据库
+func newTestDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(tb.TempDir(), "files.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	if err := migrateSchema(db, 72*time.Hour); err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+// newTestServer 以给定的环境变量在临时目录中创建完整的服务并注册路由，数据库与上传目录都在该目录中
+func newTestServer(t *testing.T, env map[string]string) *FileServer {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFileServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.db.Close() })
+	s.setupRoutes()
+	return s
+}
+
+// putUpload 以 PUT 上传内容，返回状态码与响应内容
+func putUpload(t *testing.T, s *FileServer, name string, content []byte, headers map[string]string) (int, []byte) {
+	t.Helper()
+	req := httptest.NewRequest("PUT", "/"+name, bytes.NewReader(content))
+	req.Header.Set("Accept", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, body
+}
+
+// testUpload 以 PUT 上传内容并解析返回的 JSON，上传失败时结束测试
+func testUpload(t *testing.T, s *FileServer, name string, content []byte, headers map[string]string) map[string]interface{} {
+	t.Helper()
+	status, body := putUpload(t, s, name, content, headers)
+	if status != 200 && status != 201 {
+		t.Fatalf("PUT /%s = %d: %s", name, status, body)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		t.Fatalf("PUT /%s: %v: %s", name, err, body)
+	}
+	return result
+}
+
+// newTestCtx 构造一个未经路由的请求上下文，用于测试只读取请求头与查询参数的函数
+func newTestCtx(t *testing.T, method, uri string, headers map[string]string) *fiber.Ctx {
+	t.Helper()
+	app := fiber.New()
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.Header.SetMethod(method)
+	fctx.Request.SetRequestURI(uri)
+	for k, v := range headers {
+		fctx.Request.Header.Set(k, v)
+	}
+	c := app.AcquireCtx(fctx)
+	t.Cleanup(func() { app.ReleaseCtx(c) })
+	return c
+}
+
+func TestSafeRequestFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"report.pdf", "report.pdf", true},
+		{"my report.pdf", "my report.pdf", true},
+		{"  padded.txt  ", "padded.txt", true},
+		{"报告.pdf", "报告.pdf", true},
+		{"a:b*c?.txt", "abc.txt", true},
+		{"tab\there.txt", "tabhere.txt", true},
+		{"..hidden", "hidden", true},
+
+		{"", "", false},
+		{"   ", "", false},
+		{"\u200b\u200b", "", false},
+		{"\t\r\n", "", false},
+		{".", "", false},
+		{"..", "", false},
+		{" .. ", "", false},
+		{"../etc/passwd", "", false},
+		{"dir/file.txt", "", false},
+		{`dir\file.txt`, "", false},
+		{"dir\u2215file.txt", "", false},
+		{"dir\uff0ffile.txt", "", false},
+		{"dir%2ffile.txt", "", false},
+		{"dir%5Cfile.txt", "", false},
+		{"***", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := safeRequestFilename(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("safeRequestFilename(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHasVisibleRune(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"a", true},
+		{" a ", true},
+		{"文件", true},
+		{"\u200ba", true},
+		{"", false},
+		{"  ", false},
+		{" \u3000", false},
+		{"\x00\x1f\x7f", false},
+		{"\u200b\u200e\ufeff", false},
+	}
+	for _, tt := range tests {
+		if got := hasVisibleRune(tt.name); got != tt.want {
+			t.Errorf("hasVisibleRune(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestContentDispositionFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		cd   string
+		want string
+	}{
+		{"plain", `attachment; filename="report.pdf"`, "report.pdf"},
+		{"unquoted", `attachment; filename=report.pdf`, "report.pdf"},
+		{"RFC 5987 UTF-8", `attachment; filename*=UTF-8''%E6%8A%A5%E5%91%8A.pdf`, "报告.pdf"},
+		{"RFC 5987 with language", `attachment; filename*=utf-8'zh-CN'%E6%8A%A5%E5%91%8A.pdf`, "报告.pdf"},
+		{"RFC 5987 ISO-8859-1", `attachment; filename*=ISO-8859-1''caf%E9.txt`, "café.txt"},
+		{"unquoted non-ASCII", `attachment; filename=报告.pdf`, "报告.pdf"},
+		{"quoted semicolon", `attachment; filename="a;b.txt"`, "a;b.txt"},
+		{"escaped quote", `attachment; filename="say \"hi\".txt"`, `say "hi".txt`},
+		{"filename* wins over filename", `attachment; filename="fallback.pdf"; filename*=UTF-8''%E6%8A%A5%E5%91%8A.pdf`, "报告.pdf"},
+		{"filename* wins when listed first", `attachment; filename*=UTF-8''%E6%8A%A5%E5%91%8A.pdf; filename="fallback.pdf"`, "报告.pdf"},
+		{"ISO-8859-1 filename* wins over filename", `attachment; filename="cafe.txt"; filename*=ISO-8859-1''caf%E9.txt`, "café.txt"},
+		{"unknown charset falls back to filename", `attachment; filename="fallback.pdf"; filename*=KOI8-R''%F0%D2.pdf`, "fallback.pdf"},
+		{"unknown charset alone", `attachment; filename*=KOI8-R''%F0%D2.pdf`, ""},
+		{"bad escape falls back to filename", `attachment; filename="fallback.pdf"; filename*=UTF-8''%ZZ.pdf`, "fallback.pdf"},
+		{"no filename", `attachment`, ""},
+	}
+	for _, tt := range tests {
+		if got := contentDispositionFilename(tt.cd); got != tt.want {
+			t.Errorf("%s: contentDispositionFilename(%q) = %q, want %q", tt.name, tt.cd, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeExtendedValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"UTF-8''a%20b.txt", "a b.txt"},
+		{"utf-8'en'%C3%A9.txt", "é.txt"},
+		{"iso-8859-1''%E9.txt", "é.txt"},
+		{"KOI8-R''%F0.txt", ""},
+		{"''plain.txt", ""},
+		{"UTF-8''%G0.txt", ""},
+		{"no-quotes.txt", ""},
+		{"UTF-8'only-one-quote", ""},
+	}
+	for _, tt := range tests {
+		if got := decodeExtendedValue(tt.value); got != tt.want {
+			t.Errorf("decodeExtendedValue(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestUploadFilenamePrecedence(t *testing.T) {
+	const cd = `attachment; filename="header.txt"`
+	form := []*multipart.FileHeader{{Filename: "form.txt"}}
+	tests := []struct {
+		name       string
+		precedence string
+		uri        string
+		headers    map[string]string
+		path       string
+		form       []*multipart.FileHeader
+		want       string
+	}{
+		{"X-Filename beats everything", "url", "/path.txt?filename=query.txt",
+			map[string]string{"X-Filename": "override.txt", "Content-Disposition": cd}, "path.txt", form, "override.txt"},
+		{"X-Filename is percent-decoded", "url", "/",
+			map[string]string{"X-Filename": "%E6%8A%A5%E5%91%8A.pdf"}, "", nil, "报告.pdf"},
+		{"X-Filename beats header precedence", "header", "/",
+			map[string]string{"X-Filename": "override.txt", "Content-Disposition": cd}, "path.txt", nil, "override.txt"},
+		{"URL beats Content-Disposition", "url", "/?filename=query.txt",
+			map[string]string{"Content-Disposition": cd}, "path.txt", form, "path.txt"},
+		{"Content-Disposition beats URL with header precedence", "header", "/",
+			map[string]string{"Content-Disposition": cd}, "path.txt", nil, "header.txt"},
+		{"header precedence falls back to URL", "header", "/?filename=query.txt",
+			nil, "path.txt", nil, "path.txt"},
+		{"Content-Disposition beats query", "url", "/?filename=query.txt",
+			map[string]string{"Content-Disposition": cd}, "", form, "header.txt"},
+		{"query beats form", "url", "/?filename=query%20name.txt", nil, "", form, "query name.txt"},
+		{"form filename last", "url", "/", nil, "", form, "form.txt"},
+		{"form ignored for several files", "url", "/", nil, "",
+			[]*multipart.FileHeader{{Filename: "a.txt"}, {Filename: "b.txt"}}, ""},
+		{"nothing given", "url", "/", nil, "", nil, ""},
+	}
+	for _, tt := range tests {
+		s := &FileServer{config: &Config{FilenamePrecedence: tt.precedence}}
+		c := newTestCtx(t, fiber.MethodPut, tt.uri, tt.headers)
+		if got := s.uploadFilename(c, tt.path, tt.form); got != tt.want {
+			t.Errorf("%s: uploadFilename = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUploadMimeTypeRequireContentType(t *testing.T) {
+	opaque := []byte{0x00, 0x9f, 0x13, 0x7a, 0xe2, 0x01, 0xff, 0x42}
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		name     string
+		filename string
+		declared string
+		head     []byte
+		want     string
+		rejected bool
+	}{
+		{"headerless, extensionless, opaque", "blob", "", opaque, "", true},
+		{"declared octet-stream, extensionless, opaque", "blob", "application/octet-stream", opaque, "", true},
+		{"declared octet-stream with parameters", "blob", "application/octet-stream; charset=binary", opaque, "", true},
+		{"declared octet-stream, unknown extension", "blob.xyz123", "application/octet-stream", opaque, "", true},
+
+		{"headerless, extensionless, sniffable", "image", "", png, "image/png", false},
+		{"headerless with extension", "doc.pdf", "", opaque, "application/pdf", false},
+		{"declared type", "blob", "image/webp", opaque, "image/webp", false},
+		{"declared octet-stream, text extension", "app.log", "application/octet-stream", opaque, fiber.MIMETextPlainCharsetUTF8, false},
+	}
+	for _, tt := range tests {
+		// 默认不开启时任何上传都被接受
+		s := &FileServer{config: &Config{TextExtensions: []string{".log"}}}
+		if _, err := s.uploadMimeType(tt.filename, tt.declared, tt.head); err != nil {
+			t.Errorf("%s: rejected without REQUIRE_CONTENT_TYPE: %v", tt.name, err)
+		}
+
+		s.config.RequireContentType = true
+		got, err := s.uploadMimeType(tt.filename, tt.declared, tt.head)
+		if tt.rejected {
+			if e, ok := err.(*fiber.Error); !ok || e.Code != 400 {
+				t.Errorf("%s: uploadMimeType = %q, %v, want a 400 error", tt.name, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: uploadMimeType = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestSuffixedFilename(t *testing.T) {
+	tests := []struct {
+		filename string
+		template string
+		n        int
+		want     string
+	}{
+		{"report.pdf", "-{n}", 1, "report-1.pdf"},
+		{"report.pdf", " ({n})", 2, "report (2).pdf"},
+		{"report.pdf", "_copy{n}", 3, "report_copy3.pdf"},
+		{"archive.tar.gz", "-{n}", 1, "archive.tar-1.gz"},
+		{"README", "-{n}", 10, "README-10"},
+		{".env", "-{n}", 1, "-1.env"},
+		{"报告.pdf", "-{n}", 1, "报告-1.pdf"},
+	}
+	for _, tt := range tests {
+		if got := suffixedFilename(tt.filename, tt.template, tt.n); got != tt.want {
+			t.Errorf("suffixedFilename(%q, %q, %d) = %q, want %q", tt.filename, tt.template, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestSuffixedFilenameTruncation(t *testing.T) {
+	// 主文件名截短到 255 字节以内，不截断多字节字符，扩展名与后缀保持完整
+	name := strings.Repeat("文", 84) + ".pdf"
+	got := suffixedFilename(name, " ({n})", 100)
+	if len(got) > 255 || !utf8.ValidString(got) || !strings.HasSuffix(got, " (100).pdf") {
+		t.Errorf("suffixedFilename(%d-byte name) = %q (%d bytes)", len(name), got, len(got))
+	}
+}
+
+// TestSuffixedFilenameSequence 按 storeUpload 的方式依次尝试编号，重复上传同名文件得到连续的名称，
+// 已被占用的编号 (包括手动上传的同名文件) 被跳过
+func TestSuffixedFilenameSequence(t *testing.T) {
+	tests := []struct {
+		template string
+		existing []string
+		want     []string
+	}{
+		{"-{n}", nil, []string{"report.pdf", "report-1.pdf", "report-2.pdf", "report-3.pdf"}},
+		{" ({n})", nil, []string{"report.pdf", "report (1).pdf", "report (2).pdf", "report (3).pdf"}},
+		{"_copy{n}", nil, []string{"report.pdf", "report_copy1.pdf", "report_copy2.pdf", "report_copy3.pdf"}},
+		{"-{n}", []string{"report-2.pdf"}, []string{"report.pdf", "report-1.pdf", "report-3.pdf", "report-4.pdf"}},
+	}
+	for _, tt := range tests {
+		taken := map[string]bool{}
+		for _, name := range tt.existing {
+			taken[name] = true
+		}
+		var got []string
+		for range tt.want {
+			name := "report.pdf"
+			for n := 1; taken[name] && n <= maxNameSuffix; n++ {
+				name = suffixedFilename("report.pdf", tt.template, n)
+			}
+			taken[name] = true
+			got = append(got, name)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("template %q with %v: got %q, want %q", tt.template, tt.existing, got, tt.want)
+		}
+	}
+}
+
+func TestIsDirNotEmpty(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(dir); !isDirNotEmpty(err) {
+		t.Errorf("removing a non-empty directory: isDirNotEmpty(%v) = false", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "missing")); isDirNotEmpty(err) {
+		t.Errorf("isDirNotEmpty(%v) = true", err)
+	}
+	if err := errors.New("permission denied"); isDirNotEmpty(err) {
+		t.Errorf("isDirNotEmpty(%v) = true", err)
+	}
+}
+
+// TestIdempotentRetryCorrectedExtension 保存时修正了扩展名的文件，以相同 Idempotency-Key 重试时
+// 仍覆盖之前的文件，而不是在新路径下再存一份
+func TestIdempotentRetryCorrectedExtension(t *testing.T) {
+	s := newTestServer(t, map[string]string{"EXTENSION_CHECK": "append", "IDEMPOTENCY_WINDOW": "10m"})
+	headers := map[string]string{"Idempotency-Key": "retry-1"}
+
+	first := testUpload(t, s, "photo", pngContent(100), headers)
+	if first["filename"] != "photo.png" {
+		t.Fatalf("filename = %v, want photo.png", first["filename"])
+	}
+	second := testUpload(t, s, "photo", pngContent(200), headers)
+	for _, key := range []string{"path", "filename", "deleteCode"} {
+		if second[key] != first[key] {
+			t.Errorf("retry %s = %v, want %v", key, second[key], first[key])
+		}
+	}
+
+	var count int
+	var size int64
+	if err := s.db.QueryRow("SELECT COUNT(*), MAX(file_size) FROM files").Scan(&count, &size); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || size != 200 {
+		t.Errorf("%d records (size %d), want 1 record with the retried content (200)", count, size)
+	}
+}
+
+// TestUploadRenameFailure 文件无法移动到最终位置时回滚记录：覆盖时之前的记录保持原样，
+// 新上传不留下指向不存在文件的记录
+func TestUploadRenameFailure(t *testing.T) {
+	s := newTestServer(t, map[string]string{"IDEMPOTENCY_WINDOW": "10m"})
+	headers := map[string]string{"Idempotency-Key": "retry-1"}
+	first := testUpload(t, s, "file.txt", []byte("hello"), headers)
+	dir := filepath.Join(s.uploadDir, first["path"].(string))
+
+	// 以非空目录占据目标位置，移动文件必然失败
+	block := func(name string) {
+		target := filepath.Join(dir, name)
+		os.Remove(target)
+		if err := os.MkdirAll(filepath.Join(target, "x"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	block("file.txt")
+	if status, body := putUpload(t, s, "file.txt", []byte("replaced content"), headers); status < 500 {
+		t.Fatalf("replace with a blocked target = %d: %s", status, body)
+	}
+	var size int64
+	var sha256 string
+	err := s.db.QueryRow("SELECT file_size, checksum_sha256 FROM files WHERE path = ? AND filename = 'file.txt'",
+		first["path"]).Scan(&size, &sha256)
+	if err != nil {
+		t.Fatalf("previous record lost: %v", err)
+	}
+	if want := first["checksums"].(map[string]interface{})["sha256"]; size != 5 || sha256 != want {
+		t.Errorf("previous record changed: size %d, sha256 %s", size, sha256)
+	}
+
+	block("new.txt")
+	if status, body := putUpload(t, s, "new.txt", []byte("new content"), headers); status < 500 {
+		t.Fatalf("upload with a blocked target = %d: %s", status, body)
+	}
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM files WHERE filename = 'new.txt'").Scan(&count)
+	if count != 0 {
+		t.Errorf("%d records left for a file that was never saved", count)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// cleanupMetrics 自启动以来过期清理的累计值，供 /metrics 输出
+type cleanupMetrics struct {
+	runs           atomic.Int64
+	errors         atomic.Int64
+	filesRemoved   atomic.Int64
+	bytesReclaimed atomic.Int64
+	incomplete     atomic.Int64
+}
+
+func (m *cleanupMetrics) record(result *cleanupResult) {
+	m.runs.Add(1)
+	m.errors.Add(int64(len(result.Errors)))
+	m.filesRemoved.Add(int64(result.FilesRemoved))
+	m.bytesReclaimed.Add(result.BytesFreed)
+	m.incomplete.Add(int64(result.IncompleteRemoved))
+}
+
+// handleMetrics 以 Prometheus 文本格式输出存储与清理指标，
+// 文件数与总字节数在抓取时从数据库统计
+func (s *FileServer) handleMetrics(c *fiber.Ctx) error {
+	var files, bytes int64
+	if err := s.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(COALESCE(stored_size, file_size)), 0) FROM files").Scan(&files, &bytes); err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	var b strings.Builder
+	metric := func(name, kind, help string, value int64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+	}
+	metric("tinyupload_files", "gauge", "Number of stored files.", files)
+	metric("tinyupload_stored_bytes", "gauge", "Total bytes of stored files as kept on disk or in the database.", bytes)
+	if s.config.StorageQuotaBytes > 0 {
+		metric("tinyupload_quota_bytes", "gauge", "Configured storage quota in bytes.", s.config.StorageQuotaBytes)
+	}
+	metric("tinyupload_cleanup_runs_total", "counter", "Number of expiry cleanup runs.", s.cleanupStats.runs.Load())
+	metric("tinyupload_cleanup_errors_total", "counter", "Errors encountered during expiry cleanup.", s.cleanupStats.errors.Load())
+	metric("tinyupload_cleanup_files_removed_total", "counter", "Files removed by expiry cleanup.", s.cleanupStats.filesRemoved.Load())
+	metric("tinyupload_cleanup_bytes_reclaimed_total", "counter", "Bytes reclaimed by expiry cleanup.", s.cleanupStats.bytesReclaimed.Load())
+	metric("tinyupload_cleanup_incomplete_uploads_removed_total", "counter", "Abandoned incomplete uploads removed by cleanup.", s.cleanupStats.incomplete.Load())
+
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	return c.SendString(b.String())
+}
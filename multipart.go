@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"mime/multipart"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// uploadFormFiles 按 UPLOAD_FIELD_NAMES 的顺序收集 multipart 表单中的文件，
+// 配置为 * 时接受任意文件字段；表单中的文件总数超过 MAX_FILES_PER_REQUEST 时直接拒绝
+func (s *FileServer) uploadFormFiles(c *fiber.Ctx) ([]*multipart.FileHeader, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid multipart form")
+	}
+
+	total := 0
+	for _, files := range form.File {
+		total += len(files)
+	}
+	if max := s.config.MaxFilesPerRequest; total > max {
+		return nil, fmt.Errorf("Too many files in request, maximum is %d", max)
+	}
+
+	var result []*multipart.FileHeader
+	seen := make(map[string]bool)
+	for _, name := range s.config.UploadFieldNames {
+		fields := []string{name}
+		if name == "*" {
+			fields = fields[:0]
+			for field := range form.File {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+		}
+		for _, field := range fields {
+			if !seen[field] {
+				seen[field] = true
+				result = append(result, form.File[field]...)
+			}
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("No file field found in multipart form, expected one of: %s",
+			strings.Join(s.config.UploadFieldNames, ", "))
+	}
+	return result, nil
+}
+
+// uploadFormBatch 把一次提交的多个文件保存到同一路径下并共用删除码；
+// 每个文件单独返回结果，部分失败时返回 207
+func (s *FileServer) uploadFormBatch(c *fiber.Ctx, path, storageDir, deleteCode string, meta uploadMeta, formFiles []*multipart.FileHeader) error {
+	results := make([]fiber.Map, len(formFiles))
+	files := make([]*storedFile, len(formFiles))
+	for i, fh := range formFiles {
+		f, err := s.storeFormFile(path, storageDir, deleteCode, meta, fh)
+		if err != nil {
+			results[i] = batchItemError(fiber.Map{"path": path, "filename": fh.Filename}, err)
+			continue
+		}
+		files[i] = f
+		results[i] = f.toJSON(c, deleteCode)
+		results[i]["status"] = s.config.UploadSuccessStatus
+	}
+	status := batchStatus(results, s.config.UploadSuccessStatus)
+
+	format := uploadResponseFormat(c)
+	if format == "html" {
+		var failures []uploadFailureView
+		for i, item := range results {
+			if msg, failed := item["error"]; failed {
+				failures = append(failures, uploadFailureView{formFiles[i].Filename, msg.(string)})
+			}
+		}
+		return s.renderUploadResult(c, status, deleteCode, files, failures)
+	}
+	if format == "csv" {
+		records := make([][]string, len(results))
+		for i, item := range results {
+			if msg, failed := item["error"]; failed {
+				records[i] = []string{formFiles[i].Filename, "", "", "", strconv.Itoa(item["status"].(int)), msg.(string)}
+			} else {
+				records[i] = files[i].csvRecord(c, deleteCode, s.config.UploadSuccessStatus)
+			}
+		}
+		return sendCSV(c.Status(status), records)
+	}
+	if format == "text" {
+		var b strings.Builder
+		fmt.Fprintf(&b, "Delete Code: %s\n\n", deleteCode)
+		for i, item := range results {
+			if msg, failed := item["error"]; failed {
+				fmt.Fprintf(&b, "FAILED %s: %s\n", formFiles[i].Filename, msg)
+			} else {
+				fmt.Fprintf(&b, "OK %s (%d bytes)\n", files[i].url(c), files[i].size)
+			}
+		}
+		return c.Status(status).Type("text").SendString(b.String())
+	}
+
+	return sendJSON(c.Status(status), fiber.Map{
+		"path":       path,
+		"deleteCode": deleteCode,
+		"files":      results,
+	})
+}
+
+// storeFormFile 读取并保存 multipart 中的单个文件
+func (s *FileServer) storeFormFile(path, storageDir, deleteCode string, meta uploadMeta, fh *multipart.FileHeader) (*storedFile, error) {
+	filename := sanitizeFilename(fh.Filename)
+	if filename == "" {
+		return nil, fiber.NewError(400, "Invalid filename after sanitization")
+	}
+	upload, err := s.spoolFormFile(fh)
+	if err != nil {
+		return nil, err
+	}
+	return s.storeUpload(path, storageDir, filename, deleteCode, meta, upload, fh.Header.Get("Content-Type"))
+}
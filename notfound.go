@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// notFoundCache 短时间记住不存在的 (路径, 文件名)，扫描随机地址的爬虫重复请求时不必每次查询数据库。
+// 条目数有上限，满了之后不再记录新条目，直到旧条目过期；向某个路径上传或导入文件时清除该路径的全部条目。
+// 为 nil 时 (NOT_FOUND_CACHE_TTL 为 0) 所有方法均不做任何事
+type notFoundCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	size    int
+	entries map[string]map[string]time.Time
+	// 每次清除条目时递增，查询开始后发生过清除的结果不再记录，避免与并发上传竞争时记住刚上传的文件
+	generation uint64
+	lastPurge  time.Time
+}
+
+// newNotFoundCache 按 NOT_FOUND_CACHE_TTL 与 NOT_FOUND_CACHE_SIZE 创建缓存，未启用时返回 nil
+func newNotFoundCache(config *Config) *notFoundCache {
+	if config.NotFoundCacheTTL <= 0 || config.NotFoundCacheSize <= 0 {
+		return nil
+	}
+	return &notFoundCache{
+		ttl:     config.NotFoundCacheTTL,
+		max:     config.NotFoundCacheSize,
+		entries: make(map[string]map[string]time.Time),
+	}
+}
+
+// Generation 在查询数据库之前调用，结果为不存在时连同返回值传给 Add
+func (n *notFoundCache) Generation() uint64 {
+	if n == nil {
+		return 0
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.generation
+}
+
+// Missing 返回该文件最近是否已确认不存在
+func (n *notFoundCache) Missing(path, encodedFilename string) bool {
+	if n == nil {
+		return false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	expires, ok := n.entries[path][encodedFilename]
+	return ok && time.Now().Before(expires)
+}
+
+// Add 记录一次查询确认不存在的文件；generation 为查询前 Generation 的返回值。
+// fiber 的路由参数引用会被复用的请求缓冲区，作为键保存前需复制
+func (n *notFoundCache) Add(generation uint64, path, encodedFilename string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if generation != n.generation {
+		return
+	}
+
+	now := time.Now()
+	if n.size >= n.max && now.Sub(n.lastPurge) >= n.ttl {
+		n.purge(now)
+	}
+	names := n.entries[path]
+	if _, ok := names[encodedFilename]; !ok {
+		if n.size >= n.max {
+			return
+		}
+		if names == nil {
+			names = make(map[string]time.Time)
+			n.entries[strings.Clone(path)] = names
+		}
+		n.size++
+		encodedFilename = strings.Clone(encodedFilename)
+	}
+	names[encodedFilename] = now.Add(n.ttl)
+}
+
+// Invalidate 清除路径下的全部条目，在该路径新增文件记录后调用
+func (n *notFoundCache) Invalidate(path string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.generation++
+	n.size -= len(n.entries[path])
+	delete(n.entries, path)
+}
+
+// purge 删除过期条目，调用方需持有锁
+func (n *notFoundCache) purge(now time.Time) {
+	n.lastPurge = now
+	for path, names := range n.entries {
+		for name, expires := range names {
+			if !now.Before(expires) {
+				delete(names, name)
+				n.size--
+			}
+		}
+		if len(names) == 0 {
+			delete(n.entries, path)
+		}
+	}
+}
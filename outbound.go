@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// outboundClient 服务端发起的外部请求 (验证码校验等) 统一经过此客户端：
+// 有超时、并发上限、可配置代理，并拒绝重定向到内网地址
+type outboundClient struct {
+	client *http.Client
+	slots  chan struct{}
+}
+
+func newOutboundClient(config *Config) (*outboundClient, error) {
+	proxy := http.ProxyFromEnvironment
+	if config.OutboundProxy != "" {
+		proxyURL, err := url.Parse(config.OutboundProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OUTBOUND_PROXY: %v", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+
+	return &outboundClient{
+		client: &http.Client{
+			Timeout:   config.OutboundTimeout,
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 5 {
+					return fmt.Errorf("too many redirects")
+				}
+				return checkPublicHost(req.Context(), req.URL.Hostname())
+			},
+		},
+		slots: make(chan struct{}, config.OutboundConcurrency),
+	}, nil
+}
+
+// Do 发送请求，并发数达到上限时等待空闲名额；失败时记录目标地址
+func (o *outboundClient) Do(req *http.Request) (*http.Response, error) {
+	select {
+	case o.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	case <-time.After(o.client.Timeout):
+		log.Printf("Outbound request to %s failed: no free slot", req.URL.Redacted())
+		return nil, fmt.Errorf("outbound request pool exhausted")
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		<-o.slots
+		log.Printf("Outbound request to %s failed: %v", req.URL.Redacted(), err)
+		return nil, err
+	}
+	resp.Body = &slotReleasingBody{ReadCloser: resp.Body, release: func() { <-o.slots }}
+	return resp, nil
+}
+
+// slotReleasingBody 在响应体关闭后归还并发名额
+type slotReleasingBody struct {
+	io.ReadCloser
+	release func()
+	closed  bool
+}
+
+func (b *slotReleasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if !b.closed {
+		b.closed = true
+		b.release()
+	}
+	return err
+}
+
+// checkPublicHost 解析主机名，拒绝指向回环、内网、链路本地等内部地址的目标 (SSRF 防护)
+func checkPublicHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		ip := addr.IP
+		if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+			ip.IsUnspecified() || ip.IsMulticast() {
+			return fmt.Errorf("refusing to connect to internal address %s (%s)", host, ip)
+		}
+	}
+	return nil
+}
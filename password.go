@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 下载密码的长度限制 (字符数)
+const (
+	minDownloadPasswordLength = 4
+	maxDownloadPasswordLength = 128
+)
+
+// 下载密码哈希的 PBKDF2-HMAC-SHA256 迭代次数，写入哈希中，调整后旧哈希仍可校验
+const passwordHashIterations = 100000
+
+// passwordHashScheme 存储格式 "pbkdf2-sha256$<迭代次数>$<盐>$<哈希>"，盐与哈希为十六进制
+const passwordHashScheme = "pbkdf2-sha256"
+
+// hashDownloadPassword 以随机盐计算下载密码的哈希，数据库中不保存明文
+func hashDownloadPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %v", err)
+	}
+	key := pbkdf2SHA256([]byte(password), salt, passwordHashIterations)
+	return fmt.Sprintf("%s$%d$%s$%s", passwordHashScheme, passwordHashIterations,
+		hex.EncodeToString(salt), hex.EncodeToString(key)), nil
+}
+
+// checkDownloadPassword 以常数时间比较密码与存储的哈希，哈希格式无法识别时视为不匹配
+func checkDownloadPassword(password, hash string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordHashScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(pbkdf2SHA256([]byte(password), salt, iterations), want) == 1
+}
+
+// pbkdf2SHA256 RFC 8018 的 PBKDF2，只计算第一个分块，输出与 SHA-256 等长的 32 字节
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write(binary.BigEndian.AppendUint32(nil, 1))
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// uploadPassword 读取 X-Download-Password 头 (非 ASCII 字符需百分号编码) 并返回其哈希，未设置时返回空字符串
+func uploadPassword(c *fiber.Ctx) (string, error) {
+	password := c.Get("X-Download-Password")
+	if password == "" {
+		return "", nil
+	}
+	if decoded, err := url.PathUnescape(password); err == nil {
+		password = decoded
+	}
+	if !utf8.ValidString(password) {
+		return "", fmt.Errorf("Invalid download password encoding")
+	}
+	if n := utf8.RuneCountInString(password); n < minDownloadPasswordLength || n > maxDownloadPasswordLength {
+		return "", fmt.Errorf("Download password must be %d to %d characters",
+			minDownloadPasswordLength, maxDownloadPasswordLength)
+	}
+	return hashDownloadPassword(password)
+}
+
+// downloadPasswordFromRequest 从 X-Download-Password 头或 Basic 认证的密码部分 (浏览器弹出的登录框) 读取下载密码
+func downloadPasswordFromRequest(c *fiber.Ctx) string {
+	if password := c.Get("X-Download-Password"); password != "" {
+		if decoded, err := url.PathUnescape(password); err == nil {
+			return decoded
+		}
+		return password
+	}
+	if auth := strings.TrimSpace(c.Get(fiber.HeaderAuthorization)); len(auth) > 6 && strings.EqualFold(auth[:6], "Basic ") {
+		if credentials, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth[6:])); err == nil {
+			if _, password, ok := strings.Cut(string(credentials), ":"); ok {
+				return password
+			}
+		}
+	}
+	return ""
+}
+
+// passwordAttempts 按客户端 IP 与文件记录下载密码的错误次数：窗口内连续错误达到上限后锁定一段时间，
+// 期间即使密码正确也返回 429；输入正确后清零。只保存在内存中，重启后清空
+type passwordAttempts struct {
+	mu      sync.Mutex
+	max     int
+	lockout time.Duration
+	entries map[string]*passwordAttempt
+}
+
+type passwordAttempt struct {
+	failures int
+	// 最近一次错误的时间，超过锁定时长未再出错时重新计数
+	last        time.Time
+	lockedUntil time.Time
+}
+
+func newPasswordAttempts(max int, lockout time.Duration) *passwordAttempts {
+	return &passwordAttempts{max: max, lockout: lockout, entries: make(map[string]*passwordAttempt)}
+}
+
+// Locked 返回剩余的锁定时间，未锁定时返回 false
+func (a *passwordAttempts) Locked(key string) (time.Duration, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, ok := a.entries[key]
+	if !ok {
+		return 0, false
+	}
+	remaining := time.Until(e.lockedUntil)
+	return remaining, remaining > 0
+}
+
+// Fail 记录一次错误，达到上限时开始锁定并返回 true
+func (a *passwordAttempts) Fail(key string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	for k, e := range a.entries {
+		if now.Sub(e.last) > a.lockout && now.After(e.lockedUntil) {
+			delete(a.entries, k)
+		}
+	}
+	e, ok := a.entries[key]
+	if !ok {
+		e = &passwordAttempt{}
+		a.entries[key] = e
+	}
+	e.failures++
+	e.last = now
+	if e.failures >= a.max {
+		e.failures = 0
+		e.lockedUntil = now.Add(a.lockout)
+		return true
+	}
+	return false
+}
+
+// Reset 密码正确时清除错误记录
+func (a *passwordAttempts) Reset(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.entries, key)
+}
+
+// requireDownloadPassword 校验设有下载密码的文件：提供删除码的上传者无需密码；
+// 未提供密码时返回 401 (浏览器弹出登录框)，密码错误计入该 IP 的错误次数，
+// 连续错误达到 DOWNLOAD_PASSWORD_MAX_ATTEMPTS 后在 DOWNLOAD_PASSWORD_LOCKOUT 内返回 429
+func (s *FileServer) requireDownloadPassword(c *fiber.Ctx, path, encodedFilename, passwordHash, deleteCode string) error {
+	if passwordHash == "" {
+		return nil
+	}
+	if code, err := deleteCodeFromRequest(c); err == nil && code != "" &&
+		subtle.ConstantTimeCompare([]byte(code), []byte(deleteCode)) == 1 {
+		return nil
+	}
+
+	key := clientIP(c) + "|" + path + "/" + encodedFilename
+	if remaining, locked := s.passwords.Locked(key); locked {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(remaining.Seconds())+1))
+		return fiber.NewError(429, "Too many wrong passwords, try again later")
+	}
+	password := downloadPasswordFromRequest(c)
+	if password != "" && checkDownloadPassword(password, passwordHash) {
+		s.passwords.Reset(key)
+		return nil
+	}
+	if password != "" && s.passwords.Fail(key) {
+		log.Printf("Locked downloads of %s/%s for %s after %d wrong passwords",
+			path, encodedFilename, clientIP(c), s.config.DownloadPasswordMaxAttempts)
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(s.config.DownloadPasswordLockout.Seconds())))
+		return fiber.NewError(429, "Too many wrong passwords, try again later")
+	}
+	c.Set("Cache-Control", "no-store")
+	c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="Password protected file", charset="UTF-8"`)
+	if password == "" {
+		return fiber.NewError(401, "This file is password protected, a download password is required")
+	}
+	return fiber.NewError(401, "Wrong download password")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPBKDF2SHA256Vector(t *testing.T) {
+	// RFC 7914 第 11 节 PBKDF2-HMAC-SHA256 测试向量的前 32 字节
+	got := hex.EncodeToString(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1))
+	if want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"; got != want {
+		t.Errorf("pbkdf2SHA256 = %s, want %s", got, want)
+	}
+}
+
+func TestDownloadPasswordRoundTrip(t *testing.T) {
+	for _, password := range []string{"1234", "correct horse battery staple", "密码密码"} {
+		hash, err := hashDownloadPassword(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(hash, password) {
+			t.Errorf("hash %q contains the password", hash)
+		}
+		if !checkDownloadPassword(password, hash) {
+			t.Errorf("checkDownloadPassword(%q) rejected the right password", password)
+		}
+		if checkDownloadPassword(password+"x", hash) || checkDownloadPassword("", hash) {
+			t.Errorf("checkDownloadPassword accepted a wrong password for %q", password)
+		}
+		// 盐随机生成，相同的密码得到不同的哈希
+		if again, _ := hashDownloadPassword(password); again == hash {
+			t.Errorf("two hashes of %q are identical", password)
+		}
+	}
+}
+
+func TestCheckDownloadPasswordStoredIterations(t *testing.T) {
+	// 迭代次数写在哈希中，调整 passwordHashIterations 后旧哈希仍可校验
+	salt := []byte("0123456789abcdef")
+	key := pbkdf2SHA256([]byte("secret"), salt, 1000)
+	hash := passwordHashScheme + "$1000$" + hex.EncodeToString(salt) + "$" + hex.EncodeToString(key)
+	if !checkDownloadPassword("secret", hash) {
+		t.Error("hash with 1000 iterations rejected")
+	}
+}
+
+func TestCheckDownloadPasswordMalformed(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"secret",
+		"bcrypt$1000$00$00",
+		passwordHashScheme + "$0$00$00",
+		passwordHashScheme + "$abc$00$00",
+		passwordHashScheme + "$1000$zz$00",
+		passwordHashScheme + "$1000$00$zz",
+		passwordHashScheme + "$1000$00",
+	} {
+		if checkDownloadPassword("secret", hash) {
+			t.Errorf("checkDownloadPassword accepted malformed hash %q", hash)
+		}
+	}
+}
+
+func TestPasswordAttemptsLockout(t *testing.T) {
+	a := newPasswordAttempts(3, time.Minute)
+	const key = "203.0.113.1|abcd/file.txt"
+
+	for i := 1; i < 3; i++ {
+		if a.Fail(key) {
+			t.Fatalf("locked after %d failures, want 3", i)
+		}
+		if _, locked := a.Locked(key); locked {
+			t.Fatalf("Locked after %d failures", i)
+		}
+	}
+	if !a.Fail(key) {
+		t.Fatal("not locked after 3 failures")
+	}
+	remaining, locked := a.Locked(key)
+	if !locked {
+		t.Fatal("Locked = false during the lockout")
+	}
+	if remaining <= 0 || remaining > time.Minute {
+		t.Errorf("remaining lockout = %v, want within (0, 1m]", remaining)
+	}
+
+	// 其他 IP 或文件不受影响
+	if _, locked := a.Locked("203.0.113.2|abcd/file.txt"); locked {
+		t.Error("another client is locked")
+	}
+	if _, locked := a.Locked("203.0.113.1|abcd/other.txt"); locked {
+		t.Error("another file is locked")
+	}
+}
+
+func TestPasswordAttemptsLockoutExpires(t *testing.T) {
+	a := newPasswordAttempts(1, time.Minute)
+	const key = "client|file"
+	if !a.Fail(key) {
+		t.Fatal("not locked after max failures")
+	}
+	a.entries[key].lockedUntil = time.Now().Add(-time.Second)
+	if remaining, locked := a.Locked(key); locked {
+		t.Errorf("still locked after the lockout ended, remaining %v", remaining)
+	}
+}
+
+func TestPasswordAttemptsReset(t *testing.T) {
+	a := newPasswordAttempts(3, time.Minute)
+	const key = "client|file"
+	a.Fail(key)
+	a.Fail(key)
+	// 输入正确后清零，之后需要重新连续错误 3 次才锁定
+	a.Reset(key)
+	if _, ok := a.entries[key]; ok {
+		t.Fatal("entry kept after Reset")
+	}
+	if a.Fail(key) || a.Fail(key) {
+		t.Fatal("locked before 3 new failures after Reset")
+	}
+	if !a.Fail(key) {
+		t.Fatal("not locked after 3 new failures")
+	}
+}
+
+func TestPasswordAttemptsStaleEntries(t *testing.T) {
+	a := newPasswordAttempts(3, time.Minute)
+	a.Fail("stale|file")
+	a.Fail("stale|file")
+	a.Fail("recent|file")
+	a.Fail("locked|file")
+	a.Fail("locked|file")
+	a.Fail("locked|file")
+
+	// 超过锁定时长没有再出错、也不在锁定中的记录在下次记录错误时清除
+	old := time.Now().Add(-2 * time.Minute)
+	a.entries["stale|file"].last = old
+	a.entries["locked|file"].last = old
+	a.entries["locked|file"].lockedUntil = time.Now().Add(time.Minute)
+
+	a.Fail("other|file")
+	if _, ok := a.entries["stale|file"]; ok {
+		t.Error("stale entry kept")
+	}
+	if _, ok := a.entries["recent|file"]; !ok {
+		t.Error("recent entry removed")
+	}
+	if _, locked := a.Locked("locked|file"); !locked {
+		t.Error("entry removed while still locked")
+	}
+
+	// 清除后重新计数
+	if a.Fail("stale|file") || a.Fail("stale|file") {
+		t.Error("stale failures still counted")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 视频封面的最大宽度，较宽的画面按比例缩小
+const posterWidth = 640
+
+// 单次 ffmpeg 截取封面的时限
+const posterTimeout = 30 * time.Second
+
+// 同时运行的 ffmpeg 进程数，其余封面排队生成
+const maxPosterJobs = 2
+
+// findFFmpeg 开启 VIDEO_POSTERS 时在运行时查找 ffmpeg，未安装时返回空字符串，
+// 视频照常上传，只是信息页不显示封面
+func findFFmpeg(config *Config) string {
+	if !config.VideoPosters {
+		return ""
+	}
+	ffmpegPath, err := exec.LookPath(config.FFmpegPath)
+	if err != nil {
+		log.Printf("ffmpeg not found (%s), video posters are disabled", config.FFmpegPath)
+		return ""
+	}
+	log.Printf("Video posters enabled, using %s", ffmpegPath)
+	return ffmpegPath
+}
+
+// generatePoster 从视频文件中截取一帧作为封面，以 JPEG 存入记录的 poster 列；
+// 上传完成后在后台运行，失败时只记录日志，信息页退回默认图标
+func (s *FileServer) generatePoster(id int64, filePath string) {
+	s.posterJobs <- struct{}{}
+	defer func() { <-s.posterJobs }()
+
+	// 优先取第 1 秒的画面以避开片头黑屏，不足 1 秒的视频取第一帧
+	poster, err := extractPoster(s.ffmpegPath, filePath, "1")
+	if err == nil && len(poster) == 0 {
+		poster, err = extractPoster(s.ffmpegPath, filePath, "0")
+	}
+	if err == nil && len(poster) == 0 {
+		err = fmt.Errorf("no frame decoded")
+	}
+	if err != nil {
+		log.Printf("Failed to generate poster for %s: %v", filePath, err)
+		return
+	}
+	if _, err := s.execWithRetry("UPDATE files SET poster = ? WHERE id = ?", poster, id); err != nil {
+		log.Printf("Failed to save poster for %s: %v", filePath, err)
+	}
+}
+
+// extractPoster 调用 ffmpeg 截取 offset 秒处的一帧，缩放到不超过 posterWidth 后输出 JPEG
+func extractPoster(ffmpegPath, filePath, offset string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), posterTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegPath, "-v", "error", "-nostdin",
+		"-ss", offset, "-i", filePath, "-frames:v", "1",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", posterWidth),
+		"-f", "image2", "-c:v", "mjpeg", "pipe:1")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// hasPoster 判断文件是否已生成封面，查询失败时视为没有
+func (s *FileServer) hasPoster(path, encodedFilename string) bool {
+	var has bool
+	err := s.db.QueryRow("SELECT poster IS NOT NULL FROM files WHERE path = ? AND encoded_filename = ?",
+		path, encodedFilename).Scan(&has)
+	return err == nil && has
+}
+
+// handleThumbnail 返回视频的封面图片，不计入下载次数；私有文件同样需要访问码或删除码，
+// 未生成封面 (非视频、服务端没有 ffmpeg 或截取失败) 时返回 404
+func (s *FileServer) handleThumbnail(c *fiber.Ctx) error {
+	if !s.refererAllowed(c) {
+		return c.Status(403).SendString("Hotlinking not allowed")
+	}
+
+	path := c.Params("path")
+	decodedFilename, ok := decodeRequestFilename(c.Params("filename"))
+	if !ok {
+		return c.Status(404).SendString("File not found")
+	}
+
+	var poster []byte
+	var private bool
+	var deleteCode, accessCode, passwordHash string
+	var expiresAt sql.NullTime
+	encodedFilename := url.QueryEscape(decodedFilename)
+	err := s.db.QueryRow(`
+       SELECT poster, private, delete_code, COALESCE(access_code, ''), COALESCE(password_hash, ''), expires_at
+       FROM files WHERE path = ? AND encoded_filename = ?
+   `, path, encodedFilename).Scan(&poster, &private, &deleteCode, &accessCode, &passwordHash, &expiresAt)
+	if err == sql.ErrNoRows {
+		return c.Status(404).SendString("File not found")
+	}
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	if isExpired(expiresAt) {
+		return s.fileExpired(c)
+	}
+	if _, ok := privateFileCode(c, deleteCode, accessCode); private && !ok {
+		return c.Status(403).SendString("This file is private, an access code is required")
+	}
+	if err := s.requireDownloadPassword(c, path, encodedFilename, passwordHash, deleteCode); err != nil {
+		return respondError(c, err)
+	}
+	if poster == nil {
+		return c.Status(404).SendString("Thumbnail not available")
+	}
+
+	setNoIndex(c)
+	c.Set("Cache-Control", "private, max-age=300")
+	c.Set(fiber.HeaderContentType, "image/jpeg")
+	return c.Send(poster)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Prefork 时等待其他进程释放数据库写锁的时限 (毫秒)，超时后才返回 SQLITE_BUSY 交给 execWithRetry 重试
+const preforkBusyTimeout = 5000
+
+// Prefork 时子进程从数据库重新统计存储占用的间隔
+const quotaSyncInterval = time.Minute
+
+// 主进程通过该环境变量把签名密钥传给 Prefork 子进程
+const preforkSecretEnv = "TINYUPLOAD_PREFORK_SECRET"
+
+// 所有文件合计占用的存储空间
+const storageUsageQuery = "SELECT COALESCE(SUM(COALESCE(stored_size, file_size)), 0) FROM files"
+
+// databaseDSN 数据库连接参数。Prefork 时多个进程同时读写同一数据库：使用 WAL 使读取不阻塞写入，
+// SQLite 本身保证同一时刻只有一个写入者，其余写入者在 busy_timeout 内排队等待而不是立即失败
+func databaseDSN(config *Config) string {
+	if !config.Prefork {
+		return "data/files.db"
+	}
+	return fmt.Sprintf("file:data/files.db?_journal_mode=WAL&_busy_timeout=%d", preforkBusyTimeout)
+}
+
+// syncQuotaUsage Prefork 子进程中定期以数据库中的实际占用校正存储配额计数：
+// 每个进程只知道自己处理的上传与删除，过期清理也只在主进程中运行
+func (s *FileServer) syncQuotaUsage() {
+	for {
+		time.Sleep(quotaSyncInterval)
+		var used int64
+		if err := s.db.QueryRow(storageUsageQuery).Scan(&used); err != nil {
+			log.Printf("Failed to sync storage usage: %v", err)
+			continue
+		}
+		s.quota.Sync(used)
+	}
+}
+
+// processSecret 生成签发删除确认令牌与上传挑战的随机密钥。Prefork 时由主进程生成，
+// 经环境变量传给子进程，一个进程签发的令牌在其他进程中同样有效
+func processSecret(config *Config) ([]byte, error) {
+	if config.Prefork && fiber.IsChild() {
+		secret, err := hex.DecodeString(os.Getenv(preforkSecretEnv))
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("missing secret from the prefork master process")
+		}
+		return secret, nil
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %v", err)
+	}
+	if config.Prefork {
+		if err := os.Setenv(preforkSecretEnv, hex.EncodeToString(secret)); err != nil {
+			return nil, fmt.Errorf("failed to pass secret to prefork processes: %v", err)
+		}
+	}
+	return secret, nil
+}
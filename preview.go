@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// isPreviewable 只为文本和图片提供预览
+func isPreviewable(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "text/") || strings.HasPrefix(mimeType, "image/")
+}
+
+// handlePreview 返回文件开头不超过 PREVIEW_MAX_BYTES 的内容，供落地页和缩略图使用；
+// 预览不计入下载次数，并发数与下载分开计算
+func (s *FileServer) handlePreview(c *fiber.Ctx) error {
+	if !s.refererAllowed(c) {
+		return c.Status(403).SendString("Hotlinking not allowed")
+	}
+
+	path := c.Params("path")
+	decodedFilename, ok := decodeRequestFilename(c.Params("filename"))
+	if !ok {
+		return c.Status(404).SendString("File not found")
+	}
+	encodedFilename := url.QueryEscape(decodedFilename)
+
+	var filename, mimeType, storageDir, deleteCode, accessCode, passwordHash string
+	var fileSize int64
+	var uploadTime time.Time
+	var expiresAt sql.NullTime
+	var inDB, compressed, private bool
+	err := s.db.QueryRow(`
+       SELECT filename, file_size, COALESCE(mime_type, ''), COALESCE(storage_dir, path), upload_time, expires_at,
+              content IS NOT NULL, compressed, private, delete_code, COALESCE(access_code, ''),
+              COALESCE(password_hash, '')
+       FROM files WHERE path = ? AND encoded_filename = ?
+   `, path, encodedFilename).Scan(&filename, &fileSize, &mimeType, &storageDir, &uploadTime, &expiresAt, &inDB, &compressed,
+		&private, &deleteCode, &accessCode, &passwordHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).SendString("File not found")
+		}
+		return dbUnavailable(c, err)
+	}
+	if isExpired(expiresAt) {
+		return s.fileExpired(c)
+	}
+	if _, ok := privateFileCode(c, deleteCode, accessCode); private && !ok {
+		return c.Status(403).SendString("This file is private, an access code is required")
+	}
+	if err := s.requireDownloadPassword(c, path, encodedFilename, passwordHash, deleteCode); err != nil {
+		return respondError(c, err)
+	}
+	if !isPreviewable(mimeType) {
+		return c.Status(415).SendString("Preview not available for this file type")
+	}
+
+	previewKey := "preview:" + path + "/" + encodedFilename
+	if !s.downloads.Acquire(previewKey, s.config.MaxDownloadsPerFile) {
+		c.Set("Retry-After", "10")
+		return c.Status(429).SendString("Too many concurrent previews of this file")
+	}
+	onDone := func(int64) {
+		s.downloads.Release(previewKey)
+	}
+
+	limit := s.config.PreviewMaxBytes
+	setNoIndex(c)
+	c.Set("Cache-Control", "private, max-age=300")
+	size := fileSize
+	if size > limit {
+		size = limit
+		c.Set("X-Preview-Truncated", "true")
+	}
+
+	if inDB {
+		var content []byte
+		if err := s.db.QueryRow("SELECT content FROM files WHERE path = ? AND encoded_filename = ?",
+			path, encodedFilename).Scan(&content); err != nil {
+			onDone(0)
+			return dbUnavailable(c, err)
+		}
+		if compressed {
+			return serveDecompressed(c, bytes.NewReader(content), nil, size, uploadTime, filename, mimeType, onDone)
+		}
+		if int64(len(content)) < size {
+			size = int64(len(content))
+		}
+		return serveBlob(c, content[:size], filename, mimeType, uploadTime, onDone)
+	}
+
+	filePath := filepath.Join(s.uploadDir, storageDir, filename)
+	if err := s.checkWithinUploadDir(filePath); err != nil {
+		onDone(0)
+		log.Printf("Refusing to preview %s/%s: %v", path, filename, err)
+		return c.Status(404).SendString("File not found")
+	}
+	onDone = s.trackReader(filePath, onDone)
+	f, err := os.Open(filePath)
+	if err != nil {
+		onDone(0)
+		return c.Status(404).SendString("File not found")
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		onDone(0)
+		return c.Status(404).SendString("File not found")
+	}
+	if compressed {
+		return serveDecompressed(c, f, f, size, info.ModTime(), filename, mimeType, onDone)
+	}
+	if info.Size() < size {
+		size = info.Size()
+	}
+	return serveContent(c, f, f, size, info.ModTime(), filename, mimeType, onDone)
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 上传结果页中的二维码：字节模式、纠错等级 M、版本 1-20 (最多约 660 字节)，
+// 只用于编码下载地址，无需引入第三方库
+
+// qrBlocksM 纠错等级 M 下各版本的分块：每块纠错码字数、第一组块数及每块数据码字数、第二组块数及每块数据码字数
+var qrBlocksM = [...][5]int{
+	{10, 1, 16, 0, 0}, {16, 1, 28, 0, 0}, {26, 1, 44, 0, 0}, {18, 2, 32, 0, 0}, {24, 2, 43, 0, 0},
+	{16, 4, 27, 0, 0}, {18, 4, 31, 0, 0}, {22, 2, 38, 2, 39}, {22, 3, 36, 2, 37}, {26, 4, 43, 1, 44},
+	{30, 1, 50, 4, 51}, {22, 6, 36, 2, 37}, {22, 8, 37, 1, 38}, {24, 4, 40, 5, 41}, {24, 5, 41, 5, 42},
+	{28, 7, 45, 3, 46}, {28, 10, 46, 1, 47}, {26, 9, 43, 4, 44}, {26, 3, 44, 11, 45}, {26, 3, 41, 13, 42},
+}
+
+// qrAlignment 各版本校正图形中心的行列坐标
+var qrAlignment = [...][]int{
+	nil, {6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34}, {6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50},
+	{6, 30, 54}, {6, 32, 58}, {6, 34, 62}, {6, 26, 46, 66}, {6, 26, 48, 70}, {6, 26, 50, 74},
+	{6, 30, 54, 78}, {6, 30, 56, 82}, {6, 30, 58, 86}, {6, 34, 62, 90},
+}
+
+type qrCode struct {
+	size    int
+	modules [][]bool
+	// 定位、定时、校正图形及格式与版本信息所在的模块，不放置数据也不加掩码
+	isFunc [][]bool
+}
+
+// qrSVG 把文本编码为二维码并输出 SVG，内容过长时返回空字符串
+func qrSVG(text string) string {
+	qr := encodeQR([]byte(text))
+	if qr == nil {
+		return ""
+	}
+	const quiet = 4
+	var path strings.Builder
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.modules[y][x] {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+quiet, y+quiet)
+			}
+		}
+	}
+	n := qr.size + 2*quiet
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path d="%s" fill="#000"/></svg>`, n, n, path.String())
+}
+
+// encodeQR 选择能容纳数据的最小版本，返回加好掩码的二维码；超过版本 20 的容量时返回 nil
+func encodeQR(data []byte) *qrCode {
+	version := 0
+	for v := 1; v <= len(qrBlocksM); v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*qrDataCodewords(v) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil
+	}
+
+	qr := &qrCode{size: version*4 + 17}
+	qr.modules = make([][]bool, qr.size)
+	qr.isFunc = make([][]bool, qr.size)
+	for i := range qr.modules {
+		qr.modules[i] = make([]bool, qr.size)
+		qr.isFunc[i] = make([]bool, qr.size)
+	}
+	qr.drawFunctionPatterns(version)
+	qr.drawCodewords(qrCodewords(data, version))
+
+	// 依次尝试 8 种掩码，选择惩罚分最低的
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormatBits(mask)
+		if p := qr.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		qr.applyMask(mask)
+	}
+	qr.applyMask(best)
+	qr.drawFormatBits(best)
+	return qr
+}
+
+func qrDataCodewords(version int) int {
+	b := qrBlocksM[version-1]
+	return b[1]*b[2] + b[3]*b[4]
+}
+
+// qrCodewords 按字节模式编码数据、补齐到数据容量，再分块计算纠错码并交错排列
+func qrCodewords(data []byte, version int) []byte {
+	var bits []bool
+	appendBits := func(v, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (v>>i)&1 == 1)
+		}
+	}
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	appendBits(0x4, 4)
+	appendBits(len(data), countBits)
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	capacity := 8 * qrDataCodewords(version)
+	appendBits(0, min(4, capacity-len(bits)))
+	appendBits(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		appendBits(pad, 8)
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+	}
+
+	b := qrBlocksM[version-1]
+	ecLen := b[0]
+	divisor := rsDivisor(ecLen)
+	var blocks, ecBlocks [][]byte
+	for group, offset := 0, 0; group < 2; group++ {
+		for i := 0; i < b[1+group*2]; i++ {
+			block := codewords[offset : offset+b[2+group*2]]
+			offset += len(block)
+			blocks = append(blocks, block)
+			ecBlocks = append(ecBlocks, rsRemainder(block, divisor))
+		}
+	}
+
+	var result []byte
+	for i := 0; i < max(b[2], b[4]); i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < ecLen; i++ {
+		for _, ec := range ecBlocks {
+			result = append(result, ec[i])
+		}
+	}
+	return result
+}
+
+// gfMul GF(2^8) 上的乘法，本原多项式 0x11D
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= ((int(y) >> i) & 1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsDivisor 次数为 degree 的 Reed-Solomon 生成多项式系数 (省略最高次项)
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder 数据码字除以生成多项式的余数，即纠错码字
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMul(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+func (qr *qrCode) setFunc(x, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.isFunc[y][x] = true
+}
+
+func (qr *qrCode) drawFunctionPatterns(version int) {
+	for i := 0; i < qr.size; i++ {
+		qr.setFunc(6, i, i%2 == 0)
+		qr.setFunc(i, 6, i%2 == 0)
+	}
+
+	// 三个定位图形及其分隔符
+	for _, c := range [][2]int{{3, 3}, {qr.size - 4, 3}, {3, qr.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || x >= qr.size || y < 0 || y >= qr.size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				qr.setFunc(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	// 校正图形，与定位图形重叠的三个角除外
+	pos := qrAlignment[version-1]
+	last := len(pos) - 1
+	for i := range pos {
+		for j := range pos {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qr.setFunc(pos[i]+dx, pos[j]+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// 先占住格式信息的位置，选定掩码后再写入
+	qr.drawFormatBits(0)
+
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 == 1
+			a, b := qr.size-11+i%3, i/3
+			qr.setFunc(a, b, dark)
+			qr.setFunc(b, a, dark)
+		}
+	}
+}
+
+// drawFormatBits 写入纠错等级 (M 为 00) 与掩码编号组成的格式信息，两处各一份
+func (qr *qrCode) drawFormatBits(mask int) {
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		qr.setFunc(8, i, bit(i))
+	}
+	qr.setFunc(8, 7, bit(6))
+	qr.setFunc(8, 8, bit(7))
+	qr.setFunc(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunc(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		qr.setFunc(qr.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunc(8, qr.size-15+i, bit(i))
+	}
+	// 固定的暗模块
+	qr.setFunc(8, qr.size-8, true)
+}
+
+// drawCodewords 从右下角起每两列一组蛇形放置数据位，跳过功能模块与第 6 列的定时图形
+func (qr *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < qr.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = qr.size - 1 - vert
+				}
+				if !qr.isFunc[y][x] && i < len(data)*8 {
+					qr.modules[y][x] = (data[i>>3]>>(7-i&7))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask 对数据模块应用掩码，再次调用即可撤销
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !qr.isFunc[y][x] {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty 按规范的四条规则计算惩罚分：连续同色、2x2 同色块、类似定位图形的序列、深浅比例
+func (qr *qrCode) penalty() int {
+	n := qr.size
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return qr.modules[x][y]
+		}
+		return qr.modules[y][x]
+	}
+	finderLike := []bool{true, false, true, true, true, false, true}
+	score := 0
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < n; y++ {
+			run := 1
+			for x := 1; x <= n; x++ {
+				if x < n && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+			for x := 0; x+11 <= n; x++ {
+				match := true
+				for k, dark := range finderLike {
+					if at(x+k, y, vertical) != dark {
+						match = false
+						break
+					}
+				}
+				lightAfter, lightBefore := match, true
+				for k := 0; k < 4 && match; k++ {
+					lightAfter = lightAfter && !at(x+7+k, y, vertical)
+				}
+				// 前 4 个浅色模块加定位图形样式
+				for k, dark := range finderLike {
+					if at(x+4+k, y, vertical) != dark {
+						lightBefore = false
+						break
+					}
+				}
+				for k := 0; k < 4 && lightBefore; k++ {
+					lightBefore = !at(x+k, y, vertical)
+				}
+				if lightAfter {
+					score += 40
+				}
+				if lightBefore {
+					score += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if qr.modules[y][x] {
+				dark++
+			}
+			if x+1 < n && y+1 < n {
+				c := qr.modules[y][x]
+				if qr.modules[y][x+1] == c && qr.modules[y+1][x] == c && qr.modules[y+1][x+1] == c {
+					score += 3
+				}
+			}
+		}
+	}
+	total := n * n
+	score += abs(dark*20-total*10) / total * 10
+	return score
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
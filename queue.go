@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 认领后超过该时长仍未完成发送 (如服务在发送途中重启) 的文件可被再次认领
+const queueClaimTimeout = time.Hour
+
+// 认领时最多重试的次数，并发的消费者可能先一步认领了同一个文件
+const maxClaimAttempts = 5
+
+// queuedFile 被认领的文件
+type queuedFile struct {
+	id                      int64
+	path, filename, encoded string
+	deleteCode, storageDir  string
+	mimeType                string
+	fileSize                int64
+	uploadTime              time.Time
+	inDB, compressed        bool
+}
+
+// claimNextFile 认领最早上传、尚未被下载且未过期的文件：先选出候选，再以 claimed_at 仍为空
+// (或认领已超时) 为条件更新，更新成功才算认领，多个消费者不会取到同一个文件。队列为空时返回 sql.ErrNoRows
+func (s *FileServer) claimNextFile() (*queuedFile, error) {
+	stale := fmt.Sprintf("-%d seconds", int64(queueClaimTimeout.Seconds()))
+	for attempt := 0; attempt < maxClaimAttempts; attempt++ {
+		f := &queuedFile{}
+		err := s.db.QueryRow(`
+           SELECT id, path, filename, encoded_filename, delete_code, COALESCE(storage_dir, path),
+                  COALESCE(mime_type, ''), file_size, upload_time, content IS NOT NULL, compressed
+           FROM files
+           WHERE download_count = 0 AND (claimed_at IS NULL OR claimed_at < datetime('now', ?))
+             AND (expires_at IS NULL OR expires_at > datetime('now'))
+           ORDER BY upload_time, id LIMIT 1
+       `, stale).Scan(&f.id, &f.path, &f.filename, &f.encoded, &f.deleteCode, &f.storageDir,
+			&f.mimeType, &f.fileSize, &f.uploadTime, &f.inDB, &f.compressed)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := s.execWithRetry(`
+           UPDATE files SET claimed_at = datetime('now')
+           WHERE id = ? AND download_count = 0 AND (claimed_at IS NULL OR claimed_at < datetime('now', ?))
+       `, f.id, stale)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to claim a file after %d attempts", maxClaimAttempts)
+}
+
+// releaseClaim 发送未完成时取消认领，文件回到队列中
+func (s *FileServer) releaseClaim(id int64) {
+	if _, err := s.execWithRetry("UPDATE files SET claimed_at = NULL WHERE id = ?", id); err != nil {
+		log.Printf("Failed to release claim on file %d: %v", id, err)
+	}
+}
+
+// handleQueueNext 以队列方式取出下一个文件：认领最早上传且尚未被下载的文件并发送，
+// 完整发送后删除该文件，发送中断时取消认领；队列为空时返回 204。
+// 响应头 X-File-Path 与 X-Filename 给出文件原来的路径与文件名，始终发送完整内容，不支持 Range
+func (s *FileServer) handleQueueNext(c *fiber.Ctx) error {
+	f, err := s.claimNextFile()
+	if err == sql.ErrNoRows {
+		return c.SendStatus(204)
+	}
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	onDone := func(sent int64) {
+		if sent < f.fileSize {
+			s.releaseClaim(f.id)
+			return
+		}
+		if err := s.deleteFile(f.path, f.encoded, f.deleteCode, "", false); err != nil {
+			log.Printf("Failed to delete dequeued file %s/%s: %v", f.path, f.filename, err)
+			return
+		}
+		log.Printf("Dequeued %s/%s (%d bytes)", f.path, f.filename, f.fileSize)
+	}
+
+	c.Request().Header.Del(fiber.HeaderRange)
+	c.Set("Cache-Control", "no-store")
+	c.Set("X-File-Path", f.path)
+	c.Set("X-Filename", f.encoded)
+	c.Set(fiber.HeaderContentDisposition,
+		s.contentDisposition(c, responseContentType(f.filename, f.mimeType), f.filename))
+
+	if f.inDB {
+		var content []byte
+		if err := s.db.QueryRow("SELECT content FROM files WHERE id = ?", f.id).Scan(&content); err != nil {
+			s.releaseClaim(f.id)
+			return dbUnavailable(c, err)
+		}
+		if f.compressed {
+			return serveCompressed(c, bytes.NewReader(content), nil, int64(len(content)), f.fileSize,
+				f.uploadTime, f.filename, f.mimeType, onDone)
+		}
+		return serveBlob(c, content, f.filename, f.mimeType, f.uploadTime, onDone)
+	}
+
+	filePath := filepath.Join(s.uploadDir, f.storageDir, f.filename)
+	if err := s.checkWithinUploadDir(filePath); err != nil {
+		log.Printf("Refusing to serve %s/%s: %v", f.path, f.filename, err)
+		s.releaseClaim(f.id)
+		return c.Status(500).SendString("Failed to read the claimed file")
+	}
+	onDone = s.trackReader(filePath, onDone)
+	if f.compressed {
+		err = serveCompressedFile(c, filePath, 0, f.fileSize, f.mimeType, onDone)
+	} else {
+		err = serveFile(c, filePath, f.fileSize, f.mimeType, onDone)
+	}
+	if os.IsNotExist(err) {
+		// 文件已不在磁盘上，删除其记录，避免它一直停在队首
+		log.Printf("Dequeued file %s/%s is missing on disk, removing its record", f.path, f.filename)
+		if err := s.deleteFile(f.path, f.encoded, f.deleteCode, "", false); err != nil {
+			log.Printf("Failed to remove record of %s/%s: %v", f.path, f.filename, err)
+		}
+		return c.Status(500).SendString("Claimed file is missing on disk")
+	}
+	if err != nil {
+		return c.Status(500).SendString("Failed to read the claimed file")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// storageQuota 记录已占用与预留的存储空间，防止并发上传合计超出配额
+type storageQuota struct {
+	mu       sync.Mutex
+	limit    int64 // 0 表示不限制
+	used     int64
+	reserved int64
+}
+
+func newStorageQuota(limit, used int64) *storageQuota {
+	return &storageQuota{limit: limit, used: used}
+}
+
+// Reserve 在上传开始时预留空间，超出配额时返回 false
+func (q *storageQuota) Reserve(n int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limit > 0 && q.used+q.reserved+n > q.limit {
+		return false
+	}
+	q.reserved += n
+	return true
+}
+
+// Release 上传失败时释放预留的空间
+func (q *storageQuota) Release(n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reserved -= n
+}
+
+// Commit 上传成功后将预留转为实际占用
+func (q *storageQuota) Commit(reserved, actual int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reserved -= reserved
+	q.used += actual
+}
+
+// Free 文件被删除或过期清理后归还空间
+func (q *storageQuota) Free(n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.used -= n
+	if q.used < 0 {
+		q.used = 0
+	}
+}
+
+// Sync 以数据库中统计的实际占用覆盖计数，Prefork 时各进程的计数会因其他进程的上传与删除而偏离
+func (q *storageQuota) Sync(used int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.used = used
+}
+
+// diskPressure 上传目录所在磁盘的剩余空间低于 MIN_FREE_BYTES 或 MIN_FREE_PERCENT 时返回 true，
+// 在磁盘被写满、影响同一主机上的其他服务之前拒绝上传；无法获取磁盘信息时不拦截
+func (s *FileServer) diskPressure() bool {
+	if s.config.MinFreeBytes <= 0 && s.config.MinFreePercent <= 0 {
+		return false
+	}
+	free, total, err := diskSpace(s.uploadDir)
+	if err != nil {
+		log.Printf("Failed to check free disk space: %v", err)
+		return false
+	}
+	if s.config.MinFreeBytes > 0 && free < uint64(s.config.MinFreeBytes) {
+		return true
+	}
+	return s.config.MinFreePercent > 0 && total > 0 && free*100 < total*uint64(s.config.MinFreePercent)
+}
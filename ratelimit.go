@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// tokenBucket 令牌桶：每秒补充 rate 个令牌，最多积累 burst 个，每个请求消耗一个
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// newGlobalRateLimit 按 GLOBAL_RATE_LIMIT 创建全局限速的令牌桶，未配置时返回 nil
+func newGlobalRateLimit(config *Config) *tokenBucket {
+	if config.GlobalRateLimit <= 0 {
+		return nil
+	}
+	return newTokenBucket(config.GlobalRateLimit, config.GlobalRateBurst)
+}
+
+// Allow 取出一个令牌；令牌不足时返回 false 及下一个令牌补充到位前需等待的时间
+func (b *tokenBucket) Allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// globalRateLimit 限制整个服务每秒处理的请求数，防止突发流量压垮小型实例；
+// 超出时返回 429，健康检查不受限制，以免负载高时被误判为故障
+func (s *FileServer) globalRateLimit(c *fiber.Ctx) error {
+	if c.Path() == "/health" {
+		return c.Next()
+	}
+	if ok, wait := s.rateLimit.Allow(); !ok {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return c.Status(429).SendString("Server is busy, too many requests")
+	}
+	return c.Next()
+}
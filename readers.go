@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+)
+
+// fileReaders 记录正在被读取的磁盘文件。删除有读者的文件时只登记，记录照常立即删除，
+// 等最后一个读者结束后再从磁盘移除，进行中的下载不会因文件被删除而中断或出错
+type fileReaders struct {
+	mu      sync.Mutex
+	readers map[string]int
+	// 读者结束后待移除的文件，值为移除后执行的清理 (如删除空目录)
+	pending map[string]func()
+}
+
+func newFileReaders() *fileReaders {
+	return &fileReaders{readers: make(map[string]int), pending: make(map[string]func())}
+}
+
+// Acquire 在打开文件之前登记一个读者
+func (r *fileReaders) Acquire(filePath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readers[filePath]++
+}
+
+// Release 结束一个读者；文件已被删除且没有其他读者时从磁盘移除并执行清理
+func (r *fileReaders) Release(filePath string) {
+	r.mu.Lock()
+	if r.readers[filePath]--; r.readers[filePath] > 0 {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.readers, filePath)
+	after, ok := r.pending[filePath]
+	if ok {
+		delete(r.pending, filePath)
+		// 在锁内移除，避免与 Cancel 之后写入同一位置的新文件交错
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove deferred file %s: %v", filePath, err)
+		}
+	}
+	r.mu.Unlock()
+	if ok {
+		log.Printf("Removed %s after its last download finished", filePath)
+		after()
+	}
+}
+
+// Remove 没有读者时立即移除文件并返回 false；否则推迟到最后一个读者结束后移除并执行 after，返回 true
+func (r *fileReaders) Remove(filePath string, after func()) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.readers[filePath] > 0 {
+		r.pending[filePath] = after
+		return true, nil
+	}
+	return false, os.Remove(filePath)
+}
+
+// Cancel 取消文件的推迟移除，新上传的文件即将移动到同一位置时调用
+func (r *fileReaders) Cancel(filePath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, filePath)
+}
+
+// trackReader 登记对磁盘文件的读取，返回的回调先结束登记再执行 onDone
+func (s *FileServer) trackReader(filePath string, onDone func(sent int64)) func(sent int64) {
+	s.readers.Acquire(filePath)
+	return func(sent int64) {
+		s.readers.Release(filePath)
+		onDone(sent)
+	}
+}
+
+// removeStoredFile 删除文件在磁盘上的内容及随之变空的目录；文件正被下载时推迟到下载结束
+func (s *FileServer) removeStoredFile(path, storageDir, filePath string) error {
+	deferred, err := s.readers.Remove(filePath, func() { s.removeDirIfEmpty(path, storageDir) })
+	if deferred {
+		log.Printf("Deferring removal of %s until its downloads finish", filePath)
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.removeDirIfEmpty(path, storageDir)
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, content []byte) string {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func fileExists(filePath string) bool {
+	_, err := os.Stat(filePath)
+	return err == nil
+}
+
+func TestFileReadersRemoveWithoutReaders(t *testing.T) {
+	r := newFileReaders()
+	filePath := writeTestFile(t, []byte("content"))
+
+	called := false
+	deferred, err := r.Remove(filePath, func() { called = true })
+	if err != nil || deferred {
+		t.Fatalf("Remove = %v, %v, want immediate removal", deferred, err)
+	}
+	if fileExists(filePath) {
+		t.Error("file still exists")
+	}
+	if called {
+		t.Error("after must only run for deferred removals")
+	}
+}
+
+// TestFileReadersDeleteDuringSlowDownload 在下载进行中删除文件：移除推迟到读者结束，
+// 下载读到完整的内容，最后一个读者结束后文件才从磁盘移除
+func TestFileReadersDeleteDuringSlowDownload(t *testing.T) {
+	r := newFileReaders()
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	filePath := writeTestFile(t, content)
+
+	r.Acquire(filePath)
+	f, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 每读取一块后等待，删除发生在下载中途
+	chunkRead := make(chan struct{})
+	resume := make(chan struct{})
+	done := make(chan []byte)
+	go func() {
+		defer f.Close()
+		var got []byte
+		buf := make([]byte, 1000)
+		for first := true; ; first = false {
+			n, err := f.Read(buf)
+			got = append(got, buf[:n]...)
+			if first {
+				chunkRead <- struct{}{}
+				<-resume
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("read: %v", err)
+				break
+			}
+		}
+		done <- got
+	}()
+
+	<-chunkRead
+	afterCalls := 0
+	deferred, err := r.Remove(filePath, func() { afterCalls++ })
+	if err != nil || !deferred {
+		t.Fatalf("Remove = %v, %v, want deferred removal", deferred, err)
+	}
+	if !fileExists(filePath) {
+		t.Fatal("file removed while a download is in progress")
+	}
+	close(resume)
+
+	if got := <-done; !bytes.Equal(got, content) {
+		t.Errorf("download got %d bytes, want %d", len(got), len(content))
+	}
+	if !fileExists(filePath) {
+		t.Fatal("file removed before the reader was released")
+	}
+	r.Release(filePath)
+	if fileExists(filePath) {
+		t.Error("file not removed after the last reader finished")
+	}
+	if afterCalls != 1 {
+		t.Errorf("after called %d times, want 1", afterCalls)
+	}
+}
+
+func TestFileReadersWaitsForLastReader(t *testing.T) {
+	r := newFileReaders()
+	filePath := writeTestFile(t, []byte("content"))
+
+	r.Acquire(filePath)
+	r.Acquire(filePath)
+	if deferred, err := r.Remove(filePath, func() {}); err != nil || !deferred {
+		t.Fatalf("Remove = %v, %v, want deferred removal", deferred, err)
+	}
+	r.Release(filePath)
+	if !fileExists(filePath) {
+		t.Fatal("file removed while another reader is still open")
+	}
+	r.Release(filePath)
+	if fileExists(filePath) {
+		t.Error("file not removed after the last reader finished")
+	}
+}
+
+func TestFileReadersCancel(t *testing.T) {
+	r := newFileReaders()
+	filePath := writeTestFile(t, []byte("content"))
+
+	r.Acquire(filePath)
+	called := false
+	if deferred, err := r.Remove(filePath, func() { called = true }); err != nil || !deferred {
+		t.Fatalf("Remove = %v, %v, want deferred removal", deferred, err)
+	}
+	// 新上传的文件即将写入同一位置
+	r.Cancel(filePath)
+	r.Release(filePath)
+	if !fileExists(filePath) {
+		t.Error("cancelled removal still removed the file")
+	}
+	if called {
+		t.Error("after ran for a cancelled removal")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// recoverFromDisk 扫描上传目录，为数据库中缺失记录的文件重建记录。
+// 路径取自文件所在目录名，大小、类型与校验值重新计算，删除码只能重新生成
+func (s *FileServer) recoverFromDisk() (int, error) {
+	recovered := 0
+	err := filepath.WalkDir(s.uploadDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Recover: failed to read %s: %v", filePath, err)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		storageDir, err := filepath.Rel(s.uploadDir, filepath.Dir(filePath))
+		if err != nil || storageDir == "." {
+			return nil
+		}
+		storageDir = filepath.ToSlash(storageDir)
+		path := filepath.Base(storageDir)
+		if err := validateStoragePath(path, storageDir); err != nil {
+			log.Printf("Recover: skipping %s: %v", filePath, err)
+			return nil
+		}
+		filename := d.Name()
+		if strings.HasPrefix(filename, tempFilePrefix) {
+			log.Printf("Recover: skipping unfinished upload %s", filePath)
+			return nil
+		}
+		if sanitizeFilename(filename) != filename {
+			log.Printf("Recover: skipping unexpected file %s", filePath)
+			return nil
+		}
+		encodedFilename := url.QueryEscape(filename)
+
+		var exists int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM files WHERE path = ? AND encoded_filename = ?",
+			path, encodedFilename).Scan(&exists); err != nil {
+			return err
+		}
+		if exists > 0 {
+			return nil
+		}
+
+		info, err := s.inspectFile(filePath)
+		if err != nil {
+			log.Printf("Recover: failed to inspect %s: %v", filePath, err)
+			return nil
+		}
+
+		deleteCode := generateRandomString(s.generatedDeleteCodeLength())
+		_, err = s.db.Exec(`
+           INSERT INTO files (path, filename, encoded_filename, delete_code, upload_time, expires_at, file_size, mime_type,
+                              checksum_md5, checksum_sha1, checksum_sha256, storage_dir, stored_size, compressed,
+                              retention_seconds)
+           VALUES (?, ?, ?, ?, datetime('now'), datetime('now', ?), ?, ?, ?, ?, ?, ?, ?, ?, ?)
+       `, path, filename, encodedFilename, deleteCode, expiryModifier(s.config.DefaultExpiry), info.size, info.mimeType,
+			nullIfEmpty(info.sums["md5"]), nullIfEmpty(info.sums["sha1"]), nullIfEmpty(info.sums["sha256"]), storageDir,
+			info.storedSize, info.compressed, int64(s.config.DefaultExpiry.Seconds()))
+		if err != nil {
+			return err
+		}
+		s.quota.Commit(0, info.storedSize)
+		recovered++
+		log.Printf("Recovered %s/%s (%d bytes, %s), new delete code: %s",
+			path, filename, info.size, info.mimeType, deleteCode)
+		return nil
+	})
+	return recovered, err
+}
+
+// inspectedFile 从磁盘文件重新计算得到的记录信息，size 与校验值对应原始内容
+type inspectedFile struct {
+	size       int64
+	storedSize int64
+	mimeType   string
+	sums       map[string]string
+	compressed bool
+}
+
+// inspectFile 一次读取文件，得到大小、MIME 类型与配置的校验值；
+// 扩展名为文本类型而内容是 gzip 数据的文件视为开启 COMPRESS_TEXT 时压缩存储的文件
+func (s *FileServer) inspectFile(filePath string) (*inspectedFile, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// ReadFull 读满开头或读到文件末尾，小于 sniffLength 的文件得到完整内容
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	mimeType := mime.TypeByExtension(filepath.Ext(filePath))
+	compressed := isCompressibleType(mimeType) && isGzipData(head)
+	var r io.Reader = io.MultiReader(bytes.NewReader(head), f)
+	if compressed {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	}
+
+	checksums := newChecksumWriter(s.config.ChecksumAlgorithms)
+	size, err := io.Copy(checksums, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if mimeType == "" {
+		mimeType = http.DetectContentType(head)
+	}
+	return &inspectedFile{
+		size:       size,
+		storedSize: info.Size(),
+		mimeType:   mimeType,
+		sums:       checksums.Sums(),
+		compressed: compressed,
+	}, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"text/template"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// uploadedFileView 上传结果页中的一个文件
+type uploadedFileView struct {
+	Filename   string
+	URL        string
+	Size       string
+	ExpireTime string
+	AccessCode string
+	DeleteURL  string
+	// 下载地址的二维码 SVG，地址过长无法编码时为空
+	QR string
+}
+
+// uploadFailureView 上传结果页中保存失败的文件
+type uploadFailureView struct {
+	Filename string
+	Error    string
+}
+
+// uploadFormField 首页无脚本上传表单使用的文件字段，UPLOAD_FIELD_NAMES 为 * 时使用 file
+func (s *FileServer) uploadFormField() string {
+	if name := s.config.UploadFieldNames[0]; name != "*" {
+		return name
+	}
+	return "file"
+}
+
+// renderUploadResult 浏览器表单上传成功后显示的结果页，列出下载地址、二维码与删除码；
+// 页面为 static/uploaded.html，可直接替换定制。files 与 failures 一一对应，失败的文件 files 中为 nil
+func (s *FileServer) renderUploadResult(c *fiber.Ctx, status int, deleteCode string, files []*storedFile, failures []uploadFailureView) error {
+	var views []uploadedFileView
+	for _, f := range files {
+		if f == nil {
+			continue
+		}
+		fileURL := f.url(c)
+		views = append(views, uploadedFileView{
+			Filename:   f.filename,
+			URL:        fileURL,
+			Size:       formatFileSize(f.size),
+			ExpireTime: expireTimeText(sql.NullTime{Time: f.expiresAt, Valid: true}),
+			AccessCode: f.accessCode,
+			DeleteURL: fmt.Sprintf("/delete/%s/%s?code=%s",
+				f.path, f.encodedFilename, url.QueryEscape(deleteCode)),
+			QR: qrSVG(fileURL),
+		})
+	}
+
+	// fiber 未配置模板引擎时解析模板与输出共用同一缓冲区，输出较长 (内嵌二维码) 时会覆盖尚未执行的模板，
+	// 因此在这里自行解析执行；每次请求重新读取，替换模板文件后无需重启
+	tmpl, err := template.ParseFiles("static/uploaded.html")
+	if err != nil {
+		return fmt.Errorf("failed to parse upload result page: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, fiber.Map{
+		"ServerHost": c.Hostname(),
+		"DeleteCode": deleteCode,
+		"Files":      views,
+		"Failures":   failures,
+	}); err != nil {
+		return fmt.Errorf("failed to render upload result page: %v", err)
+	}
+
+	// 页面包含删除码，不能被缓存或收录
+	setNoIndex(c)
+	c.Set("Cache-Control", "no-store")
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.Status(status).Send(buf.Bytes())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// isBusyError 判断是否为 SQLite 的暂时性锁冲突 (SQLITE_BUSY 或 SQLITE_LOCKED)，稍后重试通常即可成功
+func isBusyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// execWithRetry 执行写操作，遇到暂时性锁冲突时最多重试 DB_WRITE_RETRIES 次，
+// 等待时间从 DB_RETRY_BACKOFF 开始每次翻倍；其他错误直接返回
+func (s *FileServer) execWithRetry(query string, args ...interface{}) (sql.Result, error) {
+	delay := s.config.DBRetryBackoff
+	for attempt := 1; ; attempt++ {
+		result, err := s.db.Exec(query, args...)
+		if err == nil || !isBusyError(err) || attempt > s.config.DBWriteRetries {
+			return result, err
+		}
+		log.Printf("Database busy, retrying write in %v (attempt %d of %d): %v",
+			delay, attempt, s.config.DBWriteRetries, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// beginWithRetry 开启事务并执行其中的第一条写语句，遇到暂时性锁冲突时回滚后按 execWithRetry 的方式重试；
+// 调用方在后续步骤 (如移动文件) 成功后提交，失败时回滚
+func (s *FileServer) beginWithRetry(query string, args ...interface{}) (*sql.Tx, sql.Result, error) {
+	delay := s.config.DBRetryBackoff
+	for attempt := 1; ; attempt++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return nil, nil, err
+		}
+		result, err := tx.Exec(query, args...)
+		if err == nil {
+			return tx, result, nil
+		}
+		tx.Rollback()
+		if !isBusyError(err) || attempt > s.config.DBWriteRetries {
+			return nil, nil, err
+		}
+		log.Printf("Database busy, retrying write in %v (attempt %d of %d): %v",
+			delay, attempt, s.config.DBWriteRetries, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const base62Chars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// encodeShortID 把记录的自增 id 编码为 base62 短链接 ID
+func encodeShortID(id int64) string {
+	if id <= 0 {
+		return ""
+	}
+	var b []byte
+	for ; id > 0; id /= 62 {
+		b = append(b, base62Chars[id%62])
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+// decodeShortID 解析短链接 ID，格式不合法或溢出时返回 false
+func decodeShortID(s string) (int64, bool) {
+	if s == "" || len(s) > 10 {
+		return 0, false
+	}
+	var id int64
+	for _, r := range s {
+		i := strings.IndexRune(base62Chars, r)
+		if i < 0 {
+			return 0, false
+		}
+		id = id*62 + int64(i)
+	}
+	return id, id > 0
+}
+
+// handleShortLink 把 /d/:id 重定向到完整的下载地址；
+// 曾经存在但已删除或已过期的文件返回 410，从未分配过的 ID 返回 404
+func (s *FileServer) handleShortLink(c *fiber.Ctx) error {
+	id, ok := decodeShortID(c.Params("id"))
+	if !ok {
+		return s.fileNotFound(c)
+	}
+
+	var path, encodedFilename string
+	var expiresAt sql.NullTime
+	err := s.db.QueryRow("SELECT path, encoded_filename, expires_at FROM files WHERE id = ?", id).
+		Scan(&path, &encodedFilename, &expiresAt)
+	if err == sql.ErrNoRows {
+		// AUTOINCREMENT 不会复用 id，不超过已分配最大值的 id 说明文件已被删除或清理
+		var maxID int64
+		if err := s.db.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM sqlite_sequence WHERE name = 'files'").Scan(&maxID); err != nil {
+			return dbUnavailable(c, err)
+		}
+		if id <= maxID {
+			return c.Status(410).SendString("File has expired or been deleted")
+		}
+		return s.fileNotFound(c)
+	}
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	if expiresAt.Valid && time.Now().After(expiresAt.Time) {
+		return c.Status(410).SendString("File has expired or been deleted")
+	}
+
+	setNoIndex(c)
+	// 私有文件的短链接附带访问码，重定向时保留
+	target := fmt.Sprintf("/%s/%s", path, encodedFilename)
+	if code := c.Query("code"); code != "" {
+		target += "?code=" + url.QueryEscape(code)
+	}
+	return c.Redirect(target, 302)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newSignedTestServer(t *testing.T) *FileServer {
+	t.Helper()
+	return &FileServer{db: newTestDB(t), config: &Config{SignedURLSecret: strings.Repeat("s", 32)}}
+}
+
+func TestSignedTokenRoundTrip(t *testing.T) {
+	s := newSignedTestServer(t)
+	loc := signedLocation{StorageDir: "abcd", Filename: "file.txt", Size: 7, Expires: 1700000000, ID: 42, Checksum: "deadbeef"}
+	token, err := s.signLocation(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := s.parseSignedToken(token)
+	if !ok || *got != loc {
+		t.Fatalf("parseSignedToken = %+v, %v, want %+v", got, ok, loc)
+	}
+	// 改动令牌中的任何内容 (例如换成另一条记录) 都会使签名失效
+	if _, ok := s.parseSignedToken("x" + token); ok {
+		t.Error("tampered token accepted")
+	}
+	// 不带记录 id 的令牌无法核对内容，签名正确也不接受
+	loc.ID = 0
+	if token, _ := s.signLocation(loc); token != "" {
+		if _, ok := s.parseSignedToken(token); ok {
+			t.Error("token without a record id accepted")
+		}
+	}
+}
+
+func TestRecordChecksum(t *testing.T) {
+	tests := []struct {
+		sums map[string]string
+		want string
+	}{
+		{map[string]string{"md5": "m", "sha1": "s1", "sha256": "s256"}, "s256"},
+		{map[string]string{"md5": "m", "sha1": "s1"}, "s1"},
+		{map[string]string{"md5": "m"}, "m"},
+		{map[string]string{}, ""},
+	}
+	for _, tt := range tests {
+		if got := recordChecksum(tt.sums); got != tt.want {
+			t.Errorf("recordChecksum(%v) = %q, want %q", tt.sums, got, tt.want)
+		}
+	}
+}
+
+func TestSignedRecordMatches(t *testing.T) {
+	s := newSignedTestServer(t)
+	insert := func(path, sha256 string) int64 {
+		result, err := s.db.Exec(`
+           INSERT INTO files (path, filename, encoded_filename, delete_code, upload_time, file_size, checksum_sha256)
+           VALUES (?, 'file.txt', 'file.txt', 'code', datetime('now'), 7, ?)
+       `, path, nullIfEmpty(sha256))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := result.LastInsertId()
+		return id
+	}
+	id := insert("abcd", "aaaa")
+	noChecksum := insert("efgh", "")
+	loc := func(id int64, dir, checksum string) *signedLocation {
+		return &signedLocation{StorageDir: dir, Filename: "file.txt", Size: 7, ID: id, Checksum: checksum}
+	}
+
+	tests := []struct {
+		name string
+		loc  *signedLocation
+		want bool
+	}{
+		{"unchanged", loc(id, "abcd", "aaaa"), true},
+		{"other content", loc(id, "abcd", "bbbb"), false},
+		{"other location", loc(id, "efgh", "aaaa"), false},
+		{"deleted record", loc(id+100, "abcd", "aaaa"), false},
+		{"no checksums", loc(noChecksum, "efgh", ""), true},
+	}
+	for _, tt := range tests {
+		got, _, err := s.signedRecordMatches(tt.loc)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: signedRecordMatches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// 之后被设置了下载密码的记录不再匹配，签名下载不校验密码
+	if _, err := s.db.Exec("UPDATE files SET password_hash = 'hash' WHERE id = ?", noChecksum); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := s.signedRecordMatches(loc(noChecksum, "efgh", "")); got {
+		t.Error("token for a password-protected record still matches")
+	}
+
+	// 幂等重试替换记录时 id 不变，内容与校验值更新，旧链接不再匹配
+	if _, err := s.db.Exec("UPDATE files SET checksum_sha256 = 'cccc' WHERE id = ?", id); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := s.signedRecordMatches(loc(id, "abcd", "aaaa")); got {
+		t.Error("token for the replaced content still matches")
+	}
+	if got, _, _ := s.signedRecordMatches(loc(id, "abcd", "cccc")); !got {
+		t.Error("token for the new content does not match")
+	}
+}
+
+func TestSignedURLSkipsPasswordProtected(t *testing.T) {
+	s := newTestServer(t, map[string]string{"SIGNED_URL_SECRET": strings.Repeat("s", 32)})
+	if result := testUpload(t, s, "open.txt", []byte("content"), nil); result["signedUrl"] == nil {
+		t.Error("no signedUrl for a file without a password")
+	}
+	result := testUpload(t, s, "locked.txt", []byte("content"), map[string]string{"X-Download-Password": "secret"})
+	if result["signedUrl"] != nil {
+		t.Errorf("signedUrl %v issued for a password-protected file", result["signedUrl"])
+	}
+}
+
+func TestSignedDownloadCacheControl(t *testing.T) {
+	s := newTestServer(t, map[string]string{"SIGNED_URL_SECRET": strings.Repeat("s", 32)})
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"public.txt", nil, "public, max-age="},
+		// CDN 不得缓存私有文件
+		{"private.txt", map[string]string{"X-Private": "true"}, "private, no-store"},
+	}
+	for _, tt := range tests {
+		result := testUpload(t, s, tt.name, []byte("content"), tt.headers)
+		signedURL, _ := result["signedUrl"].(string)
+		req := httptest.NewRequest("GET", strings.TrimPrefix(signedURL, "http://example.com"), nil)
+		resp, err := s.app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s: GET %s = %d", tt.name, signedURL, resp.StatusCode)
+		}
+		if got := resp.Header.Get("Cache-Control"); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%s: Cache-Control = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+// 生成易读路径使用的短单词表
+var slugWords = []string{
+	"able", "acid", "aged", "also", "area", "army", "away", "baby", "back", "ball",
+	"band", "bank", "base", "bath", "bear", "beat", "bell", "belt", "best", "bird",
+	"blow", "blue", "boat", "body", "bold", "bone", "book", "boot", "born", "boss",
+	"both", "bowl", "bulk", "burn", "bush", "busy", "cafe", "cake", "calm", "came",
+	"camp", "card", "care", "cart", "case", "cash", "cast", "cell", "chat", "chip",
+	"city", "clay", "club", "coal", "coat", "code", "cold", "cook", "cool", "copy",
+	"corn", "cost", "crew", "crop", "dark", "data", "date", "dawn", "deal", "dear",
+	"deep", "deer", "desk", "dial", "diet", "disk", "dock", "door", "dose", "down",
+	"draw", "drop", "drum", "duck", "dust", "duty", "earn", "ease", "east", "easy",
+	"edge", "else", "even", "ever", "exit", "face", "fact", "fair", "fall", "farm",
+	"fast", "fern", "file", "film", "fine", "fire", "firm", "fish", "flag", "flat",
+	"flow", "fog", "foil", "folk", "food", "foot", "fork", "form", "fort", "free",
+	"frog", "fuel", "full", "fund", "gain", "game", "gate", "gear", "gift", "girl",
+	"glad", "glow", "goal", "gold", "golf", "good", "gray", "grid", "grow", "gulf",
+	"hair", "half", "hall", "hand", "hard", "harp", "hawk", "head", "heat", "herb",
+	"hero", "hill", "hint", "hold", "home", "hook", "hope", "horn", "host", "hour",
+	"huge", "idea", "inch", "iron", "isle", "item", "jade", "jazz", "join", "joke",
+	"jump", "keen", "keep", "kind", "king", "kite", "knee", "knot", "lake", "lamp",
+	"land", "lane", "last", "leaf", "lean", "left", "lens", "life", "lily", "lime",
+	"line", "lion", "list", "live", "load", "loaf", "lock", "loft", "long", "loop",
+	"lord", "love", "luck", "lung", "made", "mail", "main", "malt", "many", "maple",
+	"mask", "mild", "milk", "mill", "mind", "mint", "mist", "mode", "mood", "moon",
+	"moss", "most", "moth", "move", "much", "nail", "name", "navy", "near", "neat",
+	"neck", "nest", "news", "next", "nice", "nine", "noon", "nose", "note", "oak",
+	"oven", "palm", "park", "path", "peak", "pear", "pine", "pink", "pipe", "plan",
+	"play", "plum", "poem", "pond", "pool", "port", "pure", "quiz", "race", "rain",
+	"ramp", "rare", "reed", "rich", "ride", "ring", "road", "rock", "roof", "room",
+	"root", "rope", "rose", "ruby", "rule", "safe", "sail", "salt", "sand", "seal",
+	"seed", "ship", "shoe", "silk", "sing", "slow", "snow", "soap", "sock", "soft",
+	"soil", "song", "soup", "star", "stem", "step", "sun", "swan", "tail", "tale",
+	"tall", "tank", "team", "tent", "tide", "tile", "time", "tiny", "toad", "tone",
+	"tree", "trip", "tube", "tuna", "unit", "vase", "vast", "view", "vine", "wave",
+	"west", "whale", "wind", "wing", "wise", "wolf", "wood", "wool", "yard", "year",
+	"zinc", "zone",
+}
+
+// generateWordSlug 生成由三个单词组成的路径，例如 "blue-hawk-pine"，便于口头或聊天中分享
+func generateWordSlug() string {
+	parts := make([]string, 3)
+	for i := range parts {
+		n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(slugWords))))
+		parts[i] = slugWords[n.Int64()]
+	}
+	return strings.Join(parts, "-")
+}
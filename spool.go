@@ -0,0 +1,214 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// http.DetectContentType 最多读取的字节数
+const sniffLength = 512
+
+// spooledUpload 已写入上传目录下临时文件的上传内容，storeUpload 负责移动到最终位置或删除
+type spooledUpload struct {
+	tempPath string
+	size     int64
+	// 内容开头，用于识别类型
+	head []byte
+	sums map[string]string
+	// 写入时在配额中预留的字节数 (等于 size)，保存成功后转为实际占用，否则需要释放
+	quota    *storageQuota
+	reserved int64
+}
+
+// discard 删除临时文件并释放预留的配额，上传未能保存时调用
+func (u *spooledUpload) discard() {
+	u.release()
+	if err := os.Remove(u.tempPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove temp file %s: %v", u.tempPath, err)
+	}
+}
+
+// release 释放预留的配额，重复调用不会多释放
+func (u *spooledUpload) release() {
+	u.quota.Release(u.reserved)
+	u.reserved = 0
+}
+
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+// quotaWriter 写入前先在配额中预留相应的字节数，超出配额时中止写入，
+// 并发上传写入磁盘的内容合计不会超出 STORAGE_QUOTA_BYTES
+type quotaWriter struct {
+	w        io.Writer
+	quota    *storageQuota
+	reserved int64
+	written  int64
+}
+
+func (q *quotaWriter) Write(p []byte) (int, error) {
+	if need := q.written + int64(len(p)) - q.reserved; need > 0 {
+		if !q.quota.Reserve(need) {
+			return 0, errQuotaExceeded
+		}
+		q.reserved += need
+	}
+	n, err := q.w.Write(p)
+	q.written += int64(n)
+	return n, err
+}
+
+// uploadReader 记录读取上传内容时的错误，与写入临时文件的错误区分开，并保留内容开头。
+// 开头跨多次 Read 累积到 sniffLength 字节 (客户端首次只送达几个字节时也是如此)，
+// 只是内容的副本，全部内容照常依次写入临时文件，不需要再拼接回去
+type uploadReader struct {
+	r    io.Reader
+	err  error
+	head []byte
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	if len(u.head) < sniffLength {
+		u.head = append(u.head, p[:min(n, sniffLength-len(u.head))]...)
+	}
+	if err != nil && err != io.EOF {
+		u.err = err
+	}
+	return n, err
+}
+
+// spoolUpload 把上传内容边读边写入上传目录下唯一命名的临时文件，同时计算校验值，
+// 内存占用与文件大小无关；记录入库后再移动到最终位置，避免写到一半的文件被下载或清理。
+// 写入前先预留配额：已知大小 (expected 大于 0) 时在读取内容之前整体预留，其余部分边写边预留，超出时返回 507。
+// 超过 MAX_FILE_SIZE 时中止并返回 413，读取失败返回 400，出错时不留下临时文件，也不占用配额
+func (s *FileServer) spoolUpload(body io.Reader, expected int64) (*spooledUpload, error) {
+	max := s.config.MaxFileSize
+	if max > 0 && expected > max {
+		return nil, fiber.NewError(413, fmt.Sprintf("File too large, maximum size is %d bytes", max))
+	}
+	dst := &quotaWriter{quota: s.quota}
+	if expected > 0 {
+		if !s.quota.Reserve(expected) {
+			return nil, fiber.NewError(507, "Storage quota exceeded")
+		}
+		dst.reserved = expected
+	}
+
+	f, err := os.CreateTemp(s.uploadDir, tempFilePrefix+"*.tmp")
+	if err != nil {
+		s.quota.Release(dst.reserved)
+		return nil, storageWriteError(err, "Failed to save file")
+	}
+	fail := func(err error) (*spooledUpload, error) {
+		f.Close()
+		os.Remove(f.Name())
+		s.quota.Release(dst.reserved)
+		return nil, err
+	}
+
+	src := &uploadReader{r: body}
+	var r io.Reader = src
+	if max > 0 {
+		r = io.LimitReader(src, max+1)
+	}
+	dst.w = f
+	checksums := newChecksumWriter(s.config.ChecksumAlgorithms)
+	size, err := io.Copy(io.MultiWriter(dst, checksums), r)
+	if src.err != nil {
+		return fail(fiber.NewError(400, "Failed to read uploaded file"))
+	}
+	if err == errQuotaExceeded {
+		return fail(fiber.NewError(507, "Storage quota exceeded"))
+	}
+	if err != nil {
+		return fail(storageWriteError(err, "Failed to save file"))
+	}
+	if max > 0 && size > max {
+		return fail(fiber.NewError(413, fmt.Sprintf("File too large, maximum size is %d bytes", max)))
+	}
+	if err := f.Chmod(0644); err != nil {
+		return fail(storageWriteError(err, "Failed to save file"))
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		s.quota.Release(dst.reserved)
+		return nil, storageWriteError(err, "Failed to save file")
+	}
+	// 实际内容少于声明的大小时归还多预留的部分
+	s.quota.Release(dst.reserved - size)
+	return &spooledUpload{tempPath: f.Name(), size: size, head: src.head, sums: checksums.Sums(),
+		quota: s.quota, reserved: size}, nil
+}
+
+// spoolRequestBody 保存原始上传的请求体。声明了 Content-Length 时实际收到的字节数必须与之一致，
+// 客户端中途断开留下的不完整内容直接丢弃，不会写入存储或占用配额
+func (s *FileServer) spoolRequestBody(c *fiber.Ctx) (*spooledUpload, error) {
+	declared := c.Request().Header.ContentLength()
+	upload, err := s.spoolUpload(requestBodyReader(c), int64(declared))
+	if e, ok := err.(*fiber.Error); ok && e.Code == 400 {
+		log.Printf("Rejected upload from %s: failed to read body, Content-Length %d", clientIP(c), declared)
+		return nil, fiber.NewError(400, "Request body does not match Content-Length")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if declared >= 0 && upload.size != int64(declared) {
+		log.Printf("Rejected upload from %s: received %d bytes, Content-Length %d", clientIP(c), upload.size, declared)
+		upload.discard()
+		return nil, fiber.NewError(400, "Request body does not match Content-Length")
+	}
+	return upload, nil
+}
+
+// spoolFormFile 保存 multipart 文件字段的内容
+func (s *FileServer) spoolFormFile(fh *multipart.FileHeader) (*spooledUpload, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, fiber.NewError(400, "Failed to read uploaded file")
+	}
+	defer f.Close()
+	return s.spoolUpload(f, fh.Size)
+}
+
+// gzipFile 把文件压缩到同一目录下的临时文件，返回临时文件路径与压缩后的大小
+func gzipFile(src string) (string, int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", 0, err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(src), tempFilePrefix+"*.gz.tmp")
+	if err != nil {
+		return "", 0, err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = out.Chmod(0644)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", 0, err
+	}
+	info, err := os.Stat(out.Name())
+	if err != nil {
+		os.Remove(out.Name())
+		return "", 0, err
+	}
+	return out.Name(), info.Size(), nil
+}
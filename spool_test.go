@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func newSpoolTestServer(t *testing.T, quotaLimit int64) *FileServer {
+	t.Helper()
+	return &FileServer{
+		uploadDir: t.TempDir(),
+		config:    &Config{MaxFileSize: 1024 * 1024, ChecksumAlgorithms: []string{"sha256"}},
+		quota:     newStorageQuota(quotaLimit, 0),
+	}
+}
+
+// pngContent 以 PNG 文件头开头的 n 字节内容，只有读到文件头才能识别为 image/png
+func pngContent(n int) []byte {
+	data := bytes.Repeat([]byte{'x'}, n)
+	copy(data, "\x89PNG\r\n\x1a\n")
+	return data
+}
+
+func TestUploadReaderHead(t *testing.T) {
+	for _, n := range []int{1, 100, 512, 513, 4096} {
+		data := pngContent(n)
+		// 每次只读到一个字节，开头需要跨多次 Read 累积
+		r := &uploadReader{r: iotest.OneByteReader(bytes.NewReader(data))}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%d bytes: ReadAll: %v", n, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%d bytes: content changed while reading", n)
+		}
+		want := data[:min(n, sniffLength)]
+		if !bytes.Equal(r.head, want) {
+			t.Errorf("%d bytes: head has %d bytes, want %d", n, len(r.head), len(want))
+		}
+	}
+}
+
+func TestUploadReaderError(t *testing.T) {
+	readErr := errors.New("connection reset")
+	r := &uploadReader{r: iotest.ErrReader(readErr)}
+	if _, err := io.ReadAll(r); err != readErr {
+		t.Fatalf("ReadAll error = %v, want %v", err, readErr)
+	}
+	if r.err != readErr {
+		t.Errorf("err = %v, want %v", r.err, readErr)
+	}
+}
+
+func TestSpoolUploadSniffBoundary(t *testing.T) {
+	for _, n := range []int{1, 100, 513} {
+		s := newSpoolTestServer(t, 0)
+		data := pngContent(n)
+		upload, err := s.spoolUpload(iotest.HalfReader(bytes.NewReader(data)), -1)
+		if err != nil {
+			t.Fatalf("%d bytes: spoolUpload: %v", n, err)
+		}
+
+		if upload.size != int64(n) {
+			t.Errorf("%d bytes: size = %d", n, upload.size)
+		}
+		stored, err := os.ReadFile(upload.tempPath)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(stored, data) {
+			t.Errorf("%d bytes: stored content differs from the upload", n)
+		}
+		if got, want := http.DetectContentType(upload.head), http.DetectContentType(data); got != want {
+			t.Errorf("%d bytes: sniffed %q, want %q", n, got, want)
+		}
+		sum := sha256.Sum256(data)
+		if got := upload.sums["sha256"]; got != hex.EncodeToString(sum[:]) {
+			t.Errorf("%d bytes: sha256 = %s", n, got)
+		}
+		if upload.reserved != int64(n) || s.quota.reserved != int64(n) {
+			t.Errorf("%d bytes: reserved %d (quota %d), want %d", n, upload.reserved, s.quota.reserved, n)
+		}
+
+		upload.discard()
+		if _, err := os.Stat(upload.tempPath); !os.IsNotExist(err) {
+			t.Errorf("%d bytes: temp file not removed: %v", n, err)
+		}
+		if s.quota.reserved != 0 {
+			t.Errorf("%d bytes: %d bytes still reserved after discard", n, s.quota.reserved)
+		}
+	}
+}
+
+func TestSpoolUploadQuota(t *testing.T) {
+	data := pngContent(1000)
+	tests := []struct {
+		name     string
+		limit    int64
+		expected int64
+		status   int
+	}{
+		{"fits", 1000, 1000, 0},
+		{"unknown size fits", 1000, -1, 0},
+		{"declared size exceeds quota", 999, 1000, 507},
+		{"unknown size exceeds quota while writing", 999, -1, 507},
+		{"declared size smaller than the body", 999, 10, 507},
+		{"declared size exceeds MAX_FILE_SIZE", 0, 2 * 1024 * 1024, 413},
+	}
+	for _, tt := range tests {
+		s := newSpoolTestServer(t, tt.limit)
+		upload, err := s.spoolUpload(bytes.NewReader(data), tt.expected)
+		if tt.status == 0 {
+			if err != nil {
+				t.Errorf("%s: spoolUpload: %v", tt.name, err)
+				continue
+			}
+			if s.quota.reserved != int64(len(data)) {
+				t.Errorf("%s: reserved %d, want %d", tt.name, s.quota.reserved, len(data))
+			}
+			upload.discard()
+			continue
+		}
+		var e *fiber.Error
+		if !errors.As(err, &e) || e.Code != tt.status {
+			t.Errorf("%s: error = %v, want status %d", tt.name, err, tt.status)
+		}
+		if s.quota.reserved != 0 {
+			t.Errorf("%s: %d bytes still reserved after failure", tt.name, s.quota.reserved)
+		}
+		if entries, _ := os.ReadDir(s.uploadDir); len(entries) != 0 {
+			t.Errorf("%s: temp file left behind", tt.name)
+		}
+	}
+}
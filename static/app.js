@@ -130,7 +130,8 @@ class TinyUpload {
     }
 
     validateFiles(files) {
-        const maxSize = 1024 * 1024 * 1024; // 1GB
+        // 服务端 MAX_FILE_SIZE，0 表示不限制
+        const maxSize = parseInt(document.body.dataset.maxFileSize, 10) || 0;
         const validFiles = [];
 
         for (const file of files) {
@@ -139,7 +140,7 @@ class TinyUpload {
                 continue;
             }
             
-            if (file.size > maxSize) {
+            if (maxSize > 0 && file.size > maxSize) {
                 this.ui.showToast(`文件 "${file.name}" 超过大小限制，已跳过`);
                 continue;
             }
@@ -190,8 +191,43 @@ class TinyUpload {
         }
     }
 
+    // 服务端开启工作量证明时，上传前先获取题目并求解
+    async solveChallenge() {
+        if (document.body.dataset.challenge !== 'pow') return null;
+
+        this.dom.statusText.textContent = '正在进行安全验证...';
+        const response = await fetch('/challenge');
+        if (!response.ok) {
+            throw new Error(`获取验证题目失败: ${response.status}`);
+        }
+        const { challenge, difficulty } = await response.json();
+
+        const encoder = new TextEncoder();
+        for (let nonce = 0; ; nonce++) {
+            const digest = new Uint8Array(
+                await crypto.subtle.digest('SHA-256', encoder.encode(`${challenge}:${nonce}`))
+            );
+            if (this.leadingZeroBits(digest) >= difficulty) {
+                return `${challenge}:${nonce}`;
+            }
+        }
+    }
+
+    leadingZeroBits(bytes) {
+        let count = 0;
+        for (const byte of bytes) {
+            if (byte === 0) {
+                count += 8;
+                continue;
+            }
+            return count + Math.clz32(byte) - 24;
+        }
+        return count;
+    }
+
     async uploadFile(file) {
         const encodedFilename = encodeURIComponent(file.name);
+        const pow = await this.solveChallenge();
         
         return new Promise((resolve, reject) => {
             const xhr = new XMLHttpRequest();
@@ -226,6 +262,9 @@ class TinyUpload {
 
             xhr.open('PUT', `/${encodedFilename}`);
             xhr.setRequestHeader('Accept', 'application/json');
+            if (pow) {
+                xhr.setRequestHeader('X-PoW', pow);
+            }
             
             if (this.state.uploadController) {
                 this.state.uploadController.signal.addEventListener('abort', () => {
@@ -294,12 +333,22 @@ class TinyUpload {
 
     async performDelete(file) {
         const encodedFilename = encodeURIComponent(file.filename);
-        const encodedDeleteCode = encodeURIComponent(file.deleteCode);
+        const deleteUrl = `/delete/${file.path}/${encodedFilename}`;
+        // 删除码放在 Authorization 头中，避免出现在访问日志里
+        const authHeader = { 'Authorization': `Bearer ${file.deleteCode}` };
+
+        // 第一步：获取确认令牌
+        const confirmResponse = await fetch(deleteUrl, { headers: authHeader });
+        if (!confirmResponse.ok) {
+            throw new Error(`删除失败: ${confirmResponse.status}`);
+        }
+        const { confirmToken } = await confirmResponse.json();
 
-        const response = await fetch(
-            `/delete/${file.path}/${encodedFilename}?code=${encodedDeleteCode}`,
-            { method: 'DELETE' }
-        );
+        // 第二步：携带删除码和确认令牌执行删除
+        const response = await fetch(deleteUrl, {
+            method: 'DELETE',
+            headers: { ...authHeader, 'X-Confirm-Token': confirmToken }
+        });
 
         if (!response.ok) {
             throw new Error(`删除失败: ${response.status}`);
@@ -424,7 +473,7 @@ class UIManager {
                 </div>
             </div>
             <div class="file-actions">
-                <a href="/${file.path}/${encodedFilename}" class="button" download="${this.escapeHtml(file.filename)}" rel="noopener">下载</a>
+                <a href="/${file.path}/${encodedFilename}?raw=1" class="button" download="${this.escapeHtml(file.filename)}" rel="noopener">下载</a>
                 ${file.deleteCode ? `<button class="button delete-button" type="button">删除</button>` : ''}
             </div>
         `;
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// uploadTokensTable 上传令牌表，只保存令牌的 SHA-256，令牌原文仅在创建时返回一次；
+// 吊销的令牌保留记录并写入 revoked_at。quota_bytes 与 max_files 限制令牌当前占用的存储，
+// 0 表示不限制；uploaded_bytes 与 uploaded_files 为累计上传量，文件删除或过期后不减少
+const uploadTokensTable = `
+       CREATE TABLE IF NOT EXISTS upload_tokens (
+           id INTEGER PRIMARY KEY AUTOINCREMENT,
+           token_hash TEXT NOT NULL UNIQUE,
+           name TEXT NOT NULL DEFAULT '',
+           created_at DATETIME NOT NULL,
+           revoked_at DATETIME,
+           quota_bytes INTEGER NOT NULL DEFAULT 0,
+           max_files INTEGER NOT NULL DEFAULT 0,
+           uploaded_bytes INTEGER NOT NULL DEFAULT 0,
+           uploaded_files INTEGER NOT NULL DEFAULT 0
+       )
+   `
+
+// 生成的上传令牌长度
+const uploadTokenLength = 32
+
+func hashUploadToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// uploadTokenInfo 上传请求所用令牌的 id 与配额
+type uploadTokenInfo struct {
+	id         int64
+	quotaBytes int64
+	maxFiles   int64
+}
+
+// uploadToken 校验 X-Upload-Token 头，返回令牌信息；未携带令牌且不要求令牌时返回 nil。
+// 令牌无效或已吊销时返回 401 的 *fiber.Error，其他错误表示数据库不可用
+func (s *FileServer) uploadToken(c *fiber.Ctx) (*uploadTokenInfo, error) {
+	token := strings.TrimSpace(c.Get("X-Upload-Token"))
+	if token == "" {
+		if s.config.RequireUploadToken {
+			return nil, fiber.NewError(401, "Upload token required")
+		}
+		return nil, nil
+	}
+
+	t := &uploadTokenInfo{}
+	err := s.db.QueryRow("SELECT id, quota_bytes, max_files FROM upload_tokens WHERE token_hash = ? AND revoked_at IS NULL",
+		hashUploadToken(token)).Scan(&t.id, &t.quotaBytes, &t.maxFiles)
+	if err == sql.ErrNoRows {
+		return nil, fiber.NewError(401, "Invalid or revoked upload token")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// tokenStoredUsage 令牌上传且仍在存储中的文件数与存储字节数
+func (s *FileServer) tokenStoredUsage(id int64) (files, bytes int64, err error) {
+	err = s.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(COALESCE(stored_size, file_size)), 0) FROM files WHERE token_id = ?",
+		id).Scan(&files, &bytes)
+	return files, bytes, err
+}
+
+// reserveTokenQuota 检查令牌配额能否容纳一个 storedSize 字节的新文件，超出时返回 507。
+// 返回的解锁函数需在记录写入后调用，同一令牌的并发上传依次检查，合计不会超出配额
+func (s *FileServer) reserveTokenQuota(t *uploadTokenInfo, storedSize int64) (func(), error) {
+	if t == nil || (t.quotaBytes <= 0 && t.maxFiles <= 0) {
+		return func() {}, nil
+	}
+	unlock := s.tokenLocks.Lock(strconv.FormatInt(t.id, 10))
+	files, bytes, err := s.tokenStoredUsage(t.id)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	if (t.quotaBytes > 0 && bytes+storedSize > t.quotaBytes) || (t.maxFiles > 0 && files >= t.maxFiles) {
+		unlock()
+		return nil, fiber.NewError(507, "Upload token quota exceeded")
+	}
+	return unlock, nil
+}
+
+// recordTokenUpload 累计令牌的上传量，失败只记录日志，不影响已完成的上传
+func (s *FileServer) recordTokenUpload(t *uploadTokenInfo, size int64) {
+	if t == nil {
+		return
+	}
+	if _, err := s.db.Exec("UPDATE upload_tokens SET uploaded_bytes = uploaded_bytes + ?, uploaded_files = uploaded_files + 1 WHERE id = ?",
+		size, t.id); err != nil {
+		log.Printf("Failed to record usage for upload token %d: %v", t.id, err)
+	}
+}
+
+// handleCreateToken 创建上传令牌，请求体可选 {"name": "...", "quotaBytes": 0, "maxFiles": 0}，
+// name 用于标识令牌的用途，配额为 0 表示不限制
+func (s *FileServer) handleCreateToken(c *fiber.Ctx) error {
+	var req struct {
+		Name       string `json:"name"`
+		QuotaBytes int64  `json:"quotaBytes"`
+		MaxFiles   int64  `json:"maxFiles"`
+	}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).SendString("Invalid JSON body")
+		}
+	}
+	if req.QuotaBytes < 0 || req.MaxFiles < 0 {
+		return c.Status(400).SendString("Quotas must not be negative")
+	}
+	name := strings.TrimSpace(req.Name)
+
+	token := generateRandomString(uploadTokenLength)
+	result, err := s.db.Exec(`
+       INSERT INTO upload_tokens (token_hash, name, created_at, quota_bytes, max_files)
+       VALUES (?, ?, datetime('now'), ?, ?)
+   `, hashUploadToken(token), name, req.QuotaBytes, req.MaxFiles)
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	c.Set("Cache-Control", "no-store")
+	return sendJSON(c.Status(201), fiber.Map{
+		"id":         id,
+		"name":       name,
+		"token":      token,
+		"quotaBytes": req.QuotaBytes,
+		"maxFiles":   req.MaxFiles,
+		"createdAt":  time.Now().Format(timeLayout),
+	})
+}
+
+// handleListTokens 列出全部上传令牌 (不含令牌原文)，包括已吊销的
+func (s *FileServer) handleListTokens(c *fiber.Ctx) error {
+	rows, err := s.db.Query("SELECT id, name, created_at, revoked_at, quota_bytes, max_files FROM upload_tokens ORDER BY id")
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	defer rows.Close()
+
+	tokens := []fiber.Map{}
+	for rows.Next() {
+		var id, quotaBytes, maxFiles int64
+		var name string
+		var createdAt time.Time
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&id, &name, &createdAt, &revokedAt, &quotaBytes, &maxFiles); err != nil {
+			return dbUnavailable(c, err)
+		}
+		token := fiber.Map{
+			"id":         id,
+			"name":       name,
+			"createdAt":  createdAt.Local().Format(timeLayout),
+			"revoked":    revokedAt.Valid,
+			"revokedAt":  nil,
+			"quotaBytes": quotaBytes,
+			"maxFiles":   maxFiles,
+		}
+		if revokedAt.Valid {
+			token["revokedAt"] = revokedAt.Time.Local().Format(timeLayout)
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	return sendJSON(c, tokens)
+}
+
+// handleRevokeToken 吊销上传令牌，之后使用该令牌的上传返回 401；重复吊销不报错
+func (s *FileServer) handleRevokeToken(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(404).SendString("Token not found")
+	}
+
+	result, err := s.db.Exec("UPDATE upload_tokens SET revoked_at = COALESCE(revoked_at, datetime('now')) WHERE id = ?", id)
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return c.Status(404).SendString("Token not found")
+	}
+	return sendJSON(c, fiber.Map{"id": id, "revoked": true})
+}
+
+// handleTokenUsage 返回令牌的配额、当前占用的存储与累计上传量
+func (s *FileServer) handleTokenUsage(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(404).SendString("Token not found")
+	}
+
+	var name string
+	var quotaBytes, maxFiles, uploadedBytes, uploadedFiles int64
+	var revokedAt sql.NullTime
+	err = s.db.QueryRow(`
+       SELECT name, revoked_at, quota_bytes, max_files, uploaded_bytes, uploaded_files
+       FROM upload_tokens WHERE id = ?
+   `, id).Scan(&name, &revokedAt, &quotaBytes, &maxFiles, &uploadedBytes, &uploadedFiles)
+	if err == sql.ErrNoRows {
+		return c.Status(404).SendString("Token not found")
+	}
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+	storedFiles, storedBytes, err := s.tokenStoredUsage(int64(id))
+	if err != nil {
+		return dbUnavailable(c, err)
+	}
+
+	return sendJSON(c, fiber.Map{
+		"id":            id,
+		"name":          name,
+		"revoked":       revokedAt.Valid,
+		"quotaBytes":    quotaBytes,
+		"maxFiles":      maxFiles,
+		"storedFiles":   storedFiles,
+		"storedBytes":   storedBytes,
+		"uploadedFiles": uploadedFiles,
+		"uploadedBytes": uploadedBytes,
+	})
+}
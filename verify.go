@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// verifyReport 单个文件的校验结果，Status 为 ok、mismatch、missing 或 unverifiable (没有存储校验值)
+type verifyReport struct {
+	Path     string            `json:"path"`
+	Filename string            `json:"filename"`
+	Status   string            `json:"status"`
+	Expected map[string]string `json:"expected,omitempty"`
+	Actual   map[string]string `json:"actual,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// verifyRun 全量校验的进度与结果，Issues 只记录不是 ok 的文件
+type verifyRun struct {
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt *time.Time     `json:"finishedAt"`
+	Checked    int            `json:"checked"`
+	Issues     []verifyReport `json:"issues"`
+}
+
+// verifyFile 重新计算文件的校验值并与记录中的值比较，记录不存在时返回 sql.ErrNoRows
+func (s *FileServer) verifyFile(path, encodedFilename string) (verifyReport, error) {
+	var filename, storageDir string
+	var md5Sum, sha1Sum, sha256Sum sql.NullString
+	var content []byte
+	var compressed bool
+	err := s.db.QueryRow(`
+       SELECT filename, COALESCE(storage_dir, path), checksum_md5, checksum_sha1, checksum_sha256, content, compressed
+       FROM files WHERE path = ? AND encoded_filename = ?
+   `, path, encodedFilename).Scan(&filename, &storageDir, &md5Sum, &sha1Sum, &sha256Sum, &content, &compressed)
+	if err != nil {
+		return verifyReport{}, err
+	}
+
+	report := verifyReport{Path: path, Filename: filename, Expected: map[string]string{}}
+	var algorithms []string
+	for alg, sum := range map[string]sql.NullString{"md5": md5Sum, "sha1": sha1Sum, "sha256": sha256Sum} {
+		if sum.Valid {
+			report.Expected[alg] = sum.String
+			algorithms = append(algorithms, alg)
+		}
+	}
+	if len(algorithms) == 0 {
+		report.Status = "unverifiable"
+		return report, nil
+	}
+	sort.Strings(algorithms)
+
+	// 校验值对应原始内容，压缩存储的文件先解压再计算
+	var r io.Reader = bytes.NewReader(content)
+	if content == nil {
+		f, err := os.Open(filepath.Join(s.uploadDir, storageDir, filename))
+		if err != nil {
+			report.Status = "missing"
+			report.Error = err.Error()
+			return report, nil
+		}
+		defer f.Close()
+		r = f
+	}
+	if compressed {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			report.Status = "mismatch"
+			report.Error = err.Error()
+			return report, nil
+		}
+		r = zr
+	}
+	checksums := newChecksumWriter(algorithms)
+	if _, err := io.Copy(checksums, r); err != nil {
+		// 压缩数据无法解压说明存储内容已损坏
+		report.Status = "missing"
+		if compressed {
+			report.Status = "mismatch"
+		}
+		report.Error = err.Error()
+		return report, nil
+	}
+
+	report.Actual = checksums.Sums()
+	report.Status = "ok"
+	for alg, sum := range report.Expected {
+		if report.Actual[alg] != sum {
+			report.Status = "mismatch"
+		}
+	}
+	return report, nil
+}
+
+// handleVerifyFile 校验单个文件
+func (s *FileServer) handleVerifyFile(c *fiber.Ctx) error {
+	decodedFilename, ok := decodeRequestFilename(c.Params("filename"))
+	if !ok {
+		return c.Status(404).SendString("File not found")
+	}
+	report, err := s.verifyFile(c.Params("path"), url.QueryEscape(decodedFilename))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).SendString("File not found")
+		}
+		return dbUnavailable(c, err)
+	}
+	return sendJSON(c, report)
+}
+
+// handleVerifyAll 在后台逐个校验全部文件，每个文件之间停顿 VERIFY_PAUSE 以限制磁盘 I/O；
+// 已有校验在进行时返回 409
+func (s *FileServer) handleVerifyAll(c *fiber.Ctx) error {
+	s.verifyMu.Lock()
+	defer s.verifyMu.Unlock()
+	if s.lastVerify != nil && s.lastVerify.FinishedAt == nil {
+		return c.Status(409).SendString("Verification already running")
+	}
+	run := &verifyRun{StartedAt: time.Now(), Issues: []verifyReport{}}
+	s.lastVerify = run
+	go s.verifyAll(run)
+	return sendJSON(c.Status(202), run)
+}
+
+// handleVerifyStatus 返回最近一次全量校验的进度或结果
+func (s *FileServer) handleVerifyStatus(c *fiber.Ctx) error {
+	s.verifyMu.Lock()
+	defer s.verifyMu.Unlock()
+	return sendJSON(c, fiber.Map{"lastRun": s.lastVerify})
+}
+
+func (s *FileServer) verifyAll(run *verifyRun) {
+	defer func() {
+		s.verifyMu.Lock()
+		now := time.Now()
+		run.FinishedAt = &now
+		summary := fmt.Sprintf("Verification finished: %d checked, %d issues", run.Checked, len(run.Issues))
+		s.verifyMu.Unlock()
+		log.Print(summary)
+	}()
+
+	// 先取出全部键再逐个校验，避免校验期间长时间占用查询游标
+	type fileKey struct{ path, encodedFilename string }
+	rows, err := s.db.Query("SELECT path, encoded_filename FROM files ORDER BY id")
+	if err != nil {
+		log.Printf("Verification failed: %v", err)
+		return
+	}
+	var keys []fileKey
+	for rows.Next() {
+		var k fileKey
+		if err := rows.Scan(&k.path, &k.encodedFilename); err != nil {
+			rows.Close()
+			log.Printf("Verification failed: %v", err)
+			return
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+
+	for i, k := range keys {
+		if i > 0 {
+			time.Sleep(s.config.VerifyPause)
+		}
+		report, err := s.verifyFile(k.path, k.encodedFilename)
+		if err == sql.ErrNoRows {
+			// 校验期间已被删除或清理
+			continue
+		}
+		if err != nil {
+			log.Printf("Verification of %s/%s failed: %v", k.path, k.encodedFilename, err)
+			continue
+		}
+		if report.Status == "mismatch" || report.Status == "missing" {
+			log.Printf("Verification %s: %s/%s", report.Status, report.Path, report.Filename)
+		}
+
+		s.verifyMu.Lock()
+		run.Checked++
+		if report.Status != "ok" {
+			run.Issues = append(run.Issues, report)
+		}
+		s.verifyMu.Unlock()
+	}
+}